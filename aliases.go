@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// instanceAlias is a shortcut to an instance from the aliases in the config file,
+// like db: i-0123456789abcdef0 @ us-west-2. It's resolved without any scanning.
+type instanceAlias struct {
+	instanceID string
+	region     string
+}
+
+func parseAlias(s string) (instanceAlias, error) {
+	parts := strings.SplitN(s, "@", 2)
+	if len(parts) != 2 {
+		return instanceAlias{}, fmt.Errorf("%q has no region, expected <instance-id> @ <region>", s)
+	}
+
+	alias := instanceAlias{
+		instanceID: strings.TrimSpace(parts[0]),
+		region:     strings.TrimSpace(parts[1]),
+	}
+	if !isInstanceID(alias.instanceID) || alias.region == "" {
+		return instanceAlias{}, fmt.Errorf("%q is not <instance-id> @ <region>", s)
+	}
+
+	return alias, nil
+}
+
+// listAliases prints the aliases from the config file with the state of their
+// instances, marking the ones whose instance is gone as stale.
+func listAliases(ctx context.Context, opts *toolOptions, w io.Writer) error {
+	names := make([]string, 0, len(opts.fileConfig.aliases))
+	for name := range opts.fileConfig.aliases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	stale := 0
+	for _, name := range names {
+		alias := opts.fileConfig.aliases[name]
+		state, err := aliasState(ctx, opts, alias)
+		if err != nil {
+			return err
+		}
+
+		if isStale(state) {
+			state = "stale, the instance is gone"
+			stale++
+		}
+		fmt.Fprintf(w, "%s\t%s @ %s\t%s\n", name, alias.instanceID, alias.region, state)
+	}
+
+	if stale > 0 {
+		fmt.Fprintf(w, "%d of %d aliases are stale, update them in the config file\n", stale, len(names))
+	}
+
+	return nil
+}
+
+// warnStaleAlias warns on w if the instance of the alias is gone, so a stale alias
+// is noticed when it's used and not only with -list-aliases.
+func warnStaleAlias(ctx context.Context, opts *toolOptions, name string, alias instanceAlias, w io.Writer) {
+	state, err := aliasState(ctx, opts, alias)
+	if err != nil {
+		opts.logf("cannot check the alias %s: %s", name, err)
+		return
+	}
+
+	if isStale(state) {
+		fmt.Fprintf(w, "the alias %s is stale, its instance %s is gone from %s; update it in the config file\n", name, alias.instanceID, alias.region)
+	}
+}
+
+// isStale reports whether the instance state, as aliasState returns it, means the
+// instance is gone.
+func isStale(state string) bool {
+	return state == "" || state == string(types.InstanceStateNameTerminated)
+}
+
+// aliasState returns the state of the alias's instance or an empty string if it doesn't exist.
+func aliasState(ctx context.Context, opts *toolOptions, alias instanceAlias) (string, error) {
+	clients, err := clientsFor(ctx, opts, alias.region)
+	if err != nil {
+		return "", fmt.Errorf("cannot get config for AWS: %w", err)
+	}
+
+	resp, err := clients.ec2.DescribeInstances(ctx, &ec2.DescribeInstancesInput{
+		Filters: []types.Filter{
+			{
+				Name:   strp("instance-id"),
+				Values: []string{alias.instanceID},
+			},
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("cannot contact with AWS API: %w", err)
+	}
+
+	for _, r := range resp.Reservations {
+		for _, inst := range r.Instances {
+			if inst.State != nil {
+				return string(inst.State.Name), nil
+			}
+		}
+	}
+
+	return "", nil
+}
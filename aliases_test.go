@@ -0,0 +1,49 @@
+package main
+
+import "testing"
+
+func TestParseAlias(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    instanceAlias
+		wantErr bool
+	}{
+		{value: "i-0123456789abcdef0 @ us-west-2", want: instanceAlias{instanceID: "i-0123456789abcdef0", region: "us-west-2"}},
+		{value: "i-0123abcd@eu-west-1", want: instanceAlias{instanceID: "i-0123abcd", region: "eu-west-1"}},
+		{value: "i-0123456789abcdef0", wantErr: true},
+		{value: "i-0123456789abcdef0 @ ", wantErr: true},
+		{value: "db.example.com @ us-west-2", wantErr: true},
+		{value: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseAlias(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseAlias(%q) error = %v, want an error: %v", tt.value, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseAlias(%q) = %+v, want %+v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsStale(t *testing.T) {
+	tests := []struct {
+		state string
+		want  bool
+	}{
+		{state: "", want: true},
+		{state: "terminated", want: true},
+		{state: "running"},
+		{state: "stopped"},
+		{state: "shutting-down"},
+	}
+
+	for _, tt := range tests {
+		if got := isStale(tt.state); got != tt.want {
+			t.Errorf("isStale(%q) = %v, want %v", tt.state, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// expandArgFiles replaces every @file argument before the destination with the
+// arguments read from the file; the ones in the remote command are left alone.
+// The arguments in the file are separated by whitespace and can be quoted like in
+// the shell, which also goes for # comments. @file arguments in the file are
+// taken literally.
+func expandArgFiles(args []string) ([]string, error) {
+	fs := newFlagSet(&toolOptions{})
+
+	var expanded []string
+	// the arguments read from a file are not expanded again
+	literal := 0
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if literal > 0 {
+			literal--
+		} else if strings.HasPrefix(arg, "@") && len(arg) > 1 {
+			fileArgs, err := readArgFile(arg[1:])
+			if err != nil {
+				return nil, err
+			}
+
+			// the destination may come from the file too
+			args = append(append(args[:i:i], fileArgs...), args[i+1:]...)
+			literal = len(fileArgs)
+			i--
+			continue
+		}
+
+		if arg == "-" || arg == "--" || !strings.HasPrefix(arg, "-") {
+			// the destination and the remote command
+			return append(expanded, args[i:]...), nil
+		}
+
+		expanded = append(expanded, arg)
+		if optionTakesValue(fs, arg) && i+1 < len(args) {
+			i++
+			if literal > 0 {
+				literal--
+			}
+			expanded = append(expanded, args[i])
+		}
+	}
+
+	return expanded, nil
+}
+
+func readArgFile(path string) ([]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the arguments file: %w", err)
+	}
+
+	args, err := shellSplit(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("invalid arguments file %s: %w", path, err)
+	}
+
+	return args, nil
+}
+
+// optionTakesValue reports whether the ec2-ssh or ssh option is followed by its value.
+func optionTakesValue(fs *flag.FlagSet, arg string) bool {
+	parts := strings.SplitN(strings.TrimLeft(arg, "-"), "=", 2)
+	if f := fs.Lookup(parts[0]); f != nil {
+		return len(parts) == 1 && !isBoolFlag(f)
+	}
+
+	return sshFlagTakesValue(arg)
+}
+
+// shellSplit splits the text into arguments like the shell does, honoring
+// single and double quotes and backslash escapes.
+func shellSplit(text string) ([]string, error) {
+	var args []string
+	var current strings.Builder
+	inArg := false
+	var quote rune
+
+	runes := []rune(text)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case quote == '"':
+			switch {
+			case r == '"':
+				quote = 0
+			case r == '\\' && i+1 < len(runes) && strings.ContainsRune(`"\$`, runes[i+1]):
+				i++
+				current.WriteRune(runes[i])
+			default:
+				current.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inArg = true
+		case r == '\\' && i+1 < len(runes):
+			i++
+			if runes[i] != '\n' {
+				current.WriteRune(runes[i])
+				inArg = true
+			}
+		case r == '#' && !inArg:
+			// skip the comment up to the end of the line
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+		case r == ' ' || r == '\t' || r == '\n' || r == '\r':
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(r)
+			inArg = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+
+	return args, nil
+}
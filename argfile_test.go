@@ -0,0 +1,102 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestShellSplit(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		want    []string
+		wantErr bool
+	}{
+		{name: "whitespace", text: " -v\t-p  2222\r\nweb ", want: []string{"-v", "-p", "2222", "web"}},
+		{name: "single quotes", text: `'a b' 'c\d' 'e"f'`, want: []string{"a b", `c\d`, `e"f`}},
+		{name: "double quotes", text: `"a b" "c \"d\" \$e \\ \n" "f'g"`, want: []string{"a b", `c "d" $e \ \n`, "f'g"}},
+		{name: "empty quotes", text: `'' ""`, want: []string{"", ""}},
+		{name: "quotes inside an argument", text: `-o"User deploy"'s'`, want: []string{"-oUser deploys"}},
+		{name: "backslash escapes", text: `a\ b c\\d \'e`, want: []string{"a b", `c\d`, "'e"}},
+		{name: "line continuation", text: "-o \\\nUser=deploy", want: []string{"-o", "User=deploy"}},
+		{name: "comments", text: "# the profile\n-v # verbose\nweb#1 '#2'", want: []string{"-v", "web#1", "#2"}},
+		{name: "empty lines", text: "\n\n-v\n\n\nweb\n", want: []string{"-v", "web"}},
+		{name: "only comments", text: "# nothing\n\n", want: nil},
+		{name: "unterminated single quote", text: "-o 'User=deploy", wantErr: true},
+		{name: "unterminated double quote", text: `-o "User=deploy`, wantErr: true},
+		{name: "escaped quote", text: `"a\"`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := shellSplit(tt.text)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("shellSplit() = %q, want an error", got)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("shellSplit() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpandArgFiles(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"options":     "-region us-east-1 # the region\n-v\n",
+		"destination": "-l deploy web uptime",
+		"nested":      "-v @options",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	at := func(name string) string { return "@" + filepath.Join(dir, name) }
+
+	tests := []struct {
+		name    string
+		args    []string
+		want    []string
+		wantErr bool
+	}{
+		{name: "no files", args: []string{"-v", "web", "@uptime"}, want: []string{"-v", "web", "@uptime"}},
+		{name: "options", args: []string{at("options"), "-p", "2222", "web"}, want: []string{"-region", "us-east-1", "-v", "-p", "2222", "web"}},
+		{name: "destination from the file", args: []string{at("destination"), "-x"}, want: []string{"-l", "deploy", "web", "uptime", "-x"}},
+		{name: "after the destination", args: []string{"web", at("options")}, want: []string{"web", at("options")}},
+		{name: "in the remote command", args: []string{"-v", "web", "cat", at("options")}, want: []string{"-v", "web", "cat", at("options")}},
+		{name: "after --", args: []string{"--", at("options")}, want: []string{"--", at("options")}},
+		{name: "value of an ssh option", args: []string{"-l", at("options"), "web"}, want: []string{"-l", at("options"), "web"}},
+		{name: "value of an ec2-ssh option", args: []string{"-region", at("options"), "web"}, want: []string{"-region", at("options"), "web"}},
+		{name: "not expanded in a file", args: []string{at("nested"), "web"}, want: []string{"-v", "@options", "web"}},
+		{name: "missing file", args: []string{at("missing"), "web"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := expandArgFiles(tt.args)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expandArgFiles() = %q, want an error", got)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expandArgFiles() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// arn is an Amazon Resource Name in the form
+// arn:partition:service:region:account-id:resource
+type arn struct {
+	partition string
+	service   string
+	region    string
+	accountID string
+	resource  string
+}
+
+func parseARN(s string) (arn, error) {
+	parts := strings.SplitN(s, ":", 6)
+	if len(parts) != 6 || parts[0] != "arn" {
+		return arn{}, fmt.Errorf("invalid ARN %q", s)
+	}
+
+	return arn{
+		partition: parts[1],
+		service:   parts[2],
+		region:    parts[3],
+		accountID: parts[4],
+		resource:  parts[5],
+	}, nil
+}
+
+// instanceFromARN returns the ID and region of the instance from an ARN
+// like arn:aws:ec2:us-west-2:123456789012:instance/i-0123456789abcdef0
+func instanceFromARN(s string) (string, string, error) {
+	a, err := parseARN(s)
+	if err != nil {
+		return "", "", err
+	}
+
+	if a.service != "ec2" {
+		return "", "", fmt.Errorf("%s is not an EC2 ARN", s)
+	}
+
+	instanceID := strings.TrimPrefix(a.resource, "instance/")
+	if instanceID == a.resource || !isInstanceID(instanceID) {
+		return "", "", fmt.Errorf("%s is not an instance ARN", s)
+	}
+
+	return instanceID, a.region, nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import "testing"
+
+func TestInstanceFromARN(t *testing.T) {
+	tests := []struct {
+		arn        string
+		instanceID string
+		region     string
+		wantErr    bool
+	}{
+		{arn: "arn:aws:ec2:us-west-2:123456789012:instance/i-0123456789abcdef0", instanceID: "i-0123456789abcdef0", region: "us-west-2"},
+		{arn: "arn:aws-cn:ec2:cn-north-1:123456789012:instance/i-01234567", instanceID: "i-01234567", region: "cn-north-1"},
+		{arn: "arn:aws:ec2:us-west-2:123456789012", wantErr: true},
+		{arn: "aws:ec2:us-west-2:123456789012:instance/i-0123456789abcdef0:x", wantErr: true},
+		{arn: "arn:aws:rds:us-west-2:123456789012:instance/i-0123456789abcdef0", wantErr: true},
+		{arn: "arn:aws:ec2:us-west-2:123456789012:volume/vol-0123456789abcdef0", wantErr: true},
+		{arn: "arn:aws:ec2:us-west-2:123456789012:instance/web", wantErr: true},
+		{arn: "arn:aws:ec2:us-west-2:123456789012:i-0123456789abcdef0", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.arn, func(t *testing.T) {
+			instanceID, region, err := instanceFromARN(tt.arn)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("instanceFromARN() = %s, %s, want an error", instanceID, region)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			if instanceID != tt.instanceID || region != tt.region {
+				t.Errorf("instanceFromARN() = %s, %s, want %s, %s", instanceID, region, tt.instanceID, tt.region)
+			}
+		})
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// asgTag is the tag EC2 Auto Scaling puts on the instances of a group.
+const asgTag = "aws:autoscaling:groupName"
+
+// asgParallelism limits how many instances are handled at the same time with -asg-all.
+const asgParallelism = 10
+
+// asgAll uploads the key to every running instance of the -asg-all Auto Scaling group
+// and runs the remote command, if any, on all of them in parallel. Every instance runs
+// the command right after its own upload, so the key's validity isn't an issue.
+func asgAll(ctx context.Context, opts *toolOptions, args []string) error {
+	dest := destinationIndex(args)
+	if dest < 0 {
+		return errors.New("no destination given, use e.g. ec2-user@web to set the user")
+	}
+	hasCommand := len(args) > dest+1
+
+	options, err := sshOptions(ctx, opts, args)
+	if err != nil {
+		return err
+	}
+
+	instances, region, err := asgInstances(ctx, opts)
+	if err != nil {
+		return err
+	}
+
+	key := &target{options: options, instance: &instanceInfo{username: options["user"][0], region: region}}
+	defer key.close()
+
+	if opts.ephemeral {
+		// one key for all the instances
+		var pk string
+		key.tmpDir, pk, err = generateEphemeralKey(ctx, opts.keyType)
+		if err != nil {
+			return err
+		}
+
+		key.args = []string{"-i", pk, "-o", "IdentitiesOnly=yes"}
+		if key.publicKey, err = getPublicKey(pk); err != nil {
+			return fmt.Errorf("cannot read the ephemeral key: %w", err)
+		}
+	} else if err := key.loadPublicKey(ctx, opts); err != nil {
+		return err
+	}
+
+	var mu sync.Mutex
+	results := make([]error, len(instances))
+	sem := make(chan struct{}, asgParallelism)
+
+	var wg sync.WaitGroup
+	for i, inst := range instances {
+		wg.Add(1)
+		go func(i int, inst types.Instance) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			results[i] = key.runOnASGInstance(ctx, opts, inst, args, hasCommand, &mu)
+		}(i, inst)
+	}
+	wg.Wait()
+
+	failed := 0
+	for i, inst := range instances {
+		switch {
+		case results[i] != nil:
+			failed++
+			fmt.Fprintf(os.Stderr, "%s: %s\n", *inst.InstanceId, results[i])
+		case hasCommand:
+			fmt.Fprintf(os.Stderr, "%s: OK\n", *inst.InstanceId)
+		case opts.readOnly:
+			fmt.Fprintf(os.Stderr, "%s: found, read-only mode so the public key was not uploaded\n", *inst.InstanceId)
+		default:
+			fmt.Fprintf(os.Stderr, "%s: uploaded the public key, valid for %s\n", *inst.InstanceId, keyValidity)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("failed on %d of %d instances of %s", failed, len(instances), opts.asgAll)
+	}
+
+	return nil
+}
+
+// asgInstances returns the running instances of the Auto Scaling group and its region,
+// the first of the regions to scan having any.
+func asgInstances(ctx context.Context, opts *toolOptions) ([]types.Instance, string, error) {
+	scan := resolveRegions(ctx, opts)
+	for _, region := range scan {
+		clients, err := clientsFor(ctx, opts, region)
+		if err != nil {
+			return nil, "", withKind(ErrRegionScanFailed, fmt.Errorf("cannot get config for AWS: %w", err))
+		}
+
+		var instances []types.Instance
+		pages := ec2.NewDescribeInstancesPaginator(clients.ec2, &ec2.DescribeInstancesInput{
+			Filters: append([]types.Filter{
+				{
+					Name:   strp("tag:" + asgTag),
+					Values: []string{opts.asgAll},
+				},
+				{
+					Name:   strp("instance-state-name"),
+					Values: []string{string(types.InstanceStateNameRunning)},
+				},
+			}, opts.filters()...),
+		})
+		for pages.HasMorePages() {
+			resp, err := pages.NextPage(ctx)
+			if err != nil {
+				return nil, "", withKind(ErrRegionScanFailed, fmt.Errorf("cannot contact with AWS API: %w", err))
+			}
+
+			for _, r := range resp.Reservations {
+				instances = append(instances, r.Instances...)
+			}
+		}
+
+		if len(instances) > 0 {
+			opts.logf("found %d instances of %s in %s", len(instances), opts.asgAll, region)
+			return instances, region, nil
+		}
+	}
+
+	return nil, "", withKind(ErrNoInstanceFound, fmt.Errorf("the Auto Scaling group %s has no running instances in %s", opts.asgAll, strings.Join(scan, ", ")))
+}
+
+// runOnASGInstance uploads the key to the instance and runs the remote command on it,
+// prefixing its output with the instance ID.
+func (t *target) runOnASGInstance(ctx context.Context, opts *toolOptions, inst types.Instance, args []string, hasCommand bool, mu *sync.Mutex) error {
+	info := &instanceInfo{
+		username:   t.instance.username,
+		instanceID: *inst.InstanceId,
+		region:     t.instance.region,
+		ipAddress:  aws.ToString(inst.PrivateIpAddress),
+		details:    &inst,
+	}
+	if inst.Placement != nil {
+		info.availabilityZone = aws.ToString(inst.Placement.AvailabilityZone)
+	}
+
+	if err := setupEC2Instance(ctx, opts, info, t.publicKey); err != nil {
+		return err
+	}
+
+	if !hasCommand {
+		return nil
+	}
+
+	// nobody can answer prompts of parallel sessions
+	sshArgs := append([]string{"-o", "HostName=" + info.ipAddress, "-o", "BatchMode=yes"}, t.args...)
+	cmd := exec.CommandContext(ctx, "ssh", append(sshArgs, args...)...)
+	var errOut io.Writer = os.Stderr
+	if opts.mergeStderr {
+		errOut = os.Stdout
+	}
+	stdout := &prefixWriter{w: os.Stdout, prefix: info.instanceID + ": ", mu: mu}
+	stderr := &prefixWriter{w: errOut, prefix: info.instanceID + ": ", mu: mu}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	err := cmd.Run()
+	stdout.flush()
+	stderr.flush()
+	if err != nil {
+		return fmt.Errorf("the command failed: %w", err)
+	}
+
+	return nil
+}
+
+// prefixWriter writes whole lines prefixed, so the output of parallel commands
+// sharing the writer doesn't mix within a line.
+type prefixWriter struct {
+	w      io.Writer
+	prefix string
+	mu     *sync.Mutex
+	buf    []byte
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	p.buf = append(p.buf, b...)
+	for {
+		i := bytes.IndexByte(p.buf, '\n')
+		if i < 0 {
+			break
+		}
+
+		p.writeLine(p.buf[:i+1])
+		p.buf = p.buf[i+1:]
+	}
+
+	return len(b), nil
+}
+
+// flush writes the last line if it isn't terminated.
+func (p *prefixWriter) flush() {
+	if len(p.buf) > 0 {
+		p.writeLine(append(p.buf, '\n'))
+		p.buf = nil
+	}
+}
+
+func (p *prefixWriter) writeLine(line []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	fmt.Fprintf(p.w, "%s%s", p.prefix, line)
+}
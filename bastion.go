@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// bastionTagKey returns the tag of the instances naming the bastion to jump through,
+// empty if none is configured.
+func (opts *toolOptions) bastionTagKey() string {
+	if opts.bastionTag != "" {
+		return opts.bastionTag
+	}
+
+	return opts.fileConfig.BastionTag
+}
+
+// useBastion makes ssh jump through the host named by the instance's bastion tag,
+// if any, uploading the key to the bastion too when it's an EC2 instance.
+// The ProxyJump or ProxyCommand from the ssh config wins over the tag.
+func (t *target) useBastion(ctx context.Context, opts *toolOptions) error {
+	tag := opts.bastionTagKey()
+	if tag == "" || !t.found {
+		return nil
+	}
+
+	bastion := tagValue(*t.instance.details, tag)
+	if bastion == "" {
+		return nil
+	}
+
+	if t.proxy != "" {
+		opts.logf("ignoring the %s tag of %s, ssh connects through %s already", tag, t.instance, t.proxy)
+		return nil
+	}
+
+	opts.logf("jumping through %s from the %s tag of %s", bastion, tag, t.instance)
+
+	b, err := authorize(ctx, bastionOptions(opts), bastionArgs(bastion))
+	if err != nil {
+		return fmt.Errorf("cannot authorize the bastion %s: %w", bastion, err)
+	}
+
+	t.bastion = b
+	t.proxy = bastion
+	if !b.found {
+		t.args = append([]string{"-J", bastion}, t.args...)
+		return nil
+	}
+
+	// -J would connect to the bastion by the name from the tag with the default
+	// keys, so jump with the resolved address, user and key of the bastion instead
+	t.args = append([]string{"-o", "ProxyCommand=" + b.proxyCommand()}, t.args...)
+
+	return nil
+}
+
+// proxyCommand returns the ProxyCommand jumping through the target with its ssh
+// arguments, which carry the address, the user and the identity ec2-ssh set up.
+func (t *target) proxyCommand() string {
+	i := destinationIndex(t.args)
+	if i < 0 {
+		i = len(t.args)
+	}
+
+	command := []string{"ssh"}
+	for _, arg := range t.args[:i] {
+		command = append(command, escapeTokens(arg))
+	}
+	command = append(command, "-W", "[%h]:%p")
+	for _, arg := range t.args[i:] {
+		command = append(command, escapeTokens(arg))
+	}
+
+	return shellCommand(command)
+}
+
+// escapeTokens keeps ssh from expanding the % tokens of the ProxyCommand in the argument.
+func escapeTokens(arg string) string {
+	return strings.ReplaceAll(arg, "%", "%%")
+}
+
+// bastionOptions returns the options to authorize the bastion with, without
+// the ones choosing the instance, which are meant for the target only.
+func bastionOptions(opts *toolOptions) *toolOptions {
+	o := *opts
+	o.bastionTag = ""
+	o.fileConfig = &fileConfig{}
+	*o.fileConfig = *opts.fileConfig
+	o.fileConfig.BastionTag = ""
+	o.instanceID = ""
+	o.targetGroup = ""
+	o.eni = ""
+	o.matchPrefix = ""
+	o.tags = nil
+	o.vpcs = nil
+	o.dedicatedHost = ""
+	o.placementGroup = ""
+	o.connectHost = ""
+	o.start = false
+
+	return &o
+}
+
+// bastionArgs turns the [user@]host[:port] jump host into ssh arguments.
+func bastionArgs(bastion string) []string {
+	var args []string
+	if i := strings.LastIndex(bastion, "@"); i >= 0 {
+		args = append(args, "-l", bastion[:i])
+		bastion = bastion[i+1:]
+	}
+
+	if host, port, err := net.SplitHostPort(bastion); err == nil {
+		args = append(args, "-p", port)
+		bastion = host
+	}
+
+	return append(args, bastion)
+}
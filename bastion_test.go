@@ -0,0 +1,57 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBastionArgs(t *testing.T) {
+	tests := []struct {
+		bastion string
+		want    []string
+	}{
+		{bastion: "bastion", want: []string{"bastion"}},
+		{bastion: "ec2-user@bastion", want: []string{"-l", "ec2-user", "bastion"}},
+		{bastion: "ec2-user@bastion:2222", want: []string{"-l", "ec2-user", "-p", "2222", "bastion"}},
+		{bastion: "[fd00::5]:2222", want: []string{"-p", "2222", "fd00::5"}},
+	}
+
+	for _, tt := range tests {
+		if got := bastionArgs(tt.bastion); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("bastionArgs(%q) = %q, want %q", tt.bastion, got, tt.want)
+		}
+	}
+}
+
+func TestProxyCommand(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{
+			name: "ephemeral key",
+			args: []string{"-i", "/cache/ec2-ssh/keys/id_ed25519", "-o", "IdentitiesOnly=yes", "-o", "HostName=10.0.0.5", "-l", "ec2-user", "bastion"},
+			want: `'ssh' '-i' '/cache/ec2-ssh/keys/id_ed25519' '-o' 'IdentitiesOnly=yes' '-o' 'HostName=10.0.0.5' '-l' 'ec2-user' '-W' '[%h]:%p' 'bastion'`,
+		},
+		{
+			name: "PKCS#11 token",
+			args: []string{"-o", "PKCS11Provider=/usr/lib/opensc-pkcs11.so", "-o", "HostName=10.0.0.5", "-p", "2222", "bastion"},
+			want: `'ssh' '-o' 'PKCS11Provider=/usr/lib/opensc-pkcs11.so' '-o' 'HostName=10.0.0.5' '-p' '2222' '-W' '[%h]:%p' 'bastion'`,
+		},
+		{
+			name: "tokens and quotes",
+			args: []string{"-o", "ProxyCommand=nc -X 5 -x socks:1080 %h %p", "-l", "o'neil", "bastion"},
+			want: `'ssh' '-o' 'ProxyCommand=nc -X 5 -x socks:1080 %%h %%p' '-l' 'o'\''neil' '-W' '[%h]:%p' 'bastion'`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &target{args: tt.args}
+			if got := b.proxyCommand(); got != tt.want {
+				t.Errorf("proxyCommand() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
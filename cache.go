@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+// regionCache remembers in which region an instance with the given Name tag
+// was found, so the next connection can go straight to that region.
+type regionCache map[string]string
+
+func regionCachePath() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, "ec2-ssh", "regions.json"), nil
+}
+
+// loadRegionCache returns an empty cache if the file is missing or broken
+// because the cache is only an optimization.
+func loadRegionCache() regionCache {
+	cache := regionCache{}
+
+	path, err := regionCachePath()
+	if err != nil {
+		return cache
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return cache
+	}
+
+	_ = json.Unmarshal(content, &cache)
+	return cache
+}
+
+func (cache regionCache) save() error {
+	path, err := regionCachePath()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+
+	content, err := json.Marshal(cache)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, content, 0o600)
+}
+
+// regionsToScan returns the regions with the cached one, if any, moved to the front.
+func (cache regionCache) regionsToScan(name string, regions []string) []string {
+	return prependRegion(cache[name], regions)
+}
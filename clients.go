@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2instanceconnect"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// awsClients are the AWS config and API clients for a profile and region.
+type awsClients struct {
+	cfg     aws.Config
+	ec2     *ec2.Client
+	connect *ec2instanceconnect.Client
+
+	// the caller's account is fetched once, see callerAccount
+	accountOnce sync.Once
+	account     string
+	accountErr  error
+}
+
+type clientsKey struct {
+	profile  string
+	region   string
+	debugAWS bool
+	role     string
+}
+
+// clientCache keeps the clients for the whole run so resolving several
+// instances doesn't load the config and create the clients again.
+// The credentials of a profile are shared by the clients of all the regions,
+// so e.g. a credential_process runs once rather than for every region scanned.
+var clientCache = struct {
+	sync.Mutex
+	clients     map[clientsKey]*awsClients
+	credentials map[string]aws.CredentialsProvider
+}{clients: map[clientsKey]*awsClients{}, credentials: map[string]aws.CredentialsProvider{}}
+
+// clientsFor returns the clients for the profile of the options and the region,
+// creating them on the first use. It's safe for concurrent use.
+func clientsFor(ctx context.Context, opts *toolOptions, region string) (*awsClients, error) {
+	return clientsAs(ctx, opts, region, "")
+}
+
+// clientsAs returns the clients like clientsFor, but using the credentials
+// of the role assumed by the profile if the role is not empty.
+func clientsAs(ctx context.Context, opts *toolOptions, region, role string) (*awsClients, error) {
+	key := clientsKey{profile: opts.profile, region: region, debugAWS: opts.debugAWS, role: role}
+
+	clientCache.Lock()
+	defer clientCache.Unlock()
+
+	if c, ok := clientCache.clients[key]; ok {
+		return c, nil
+	}
+
+	cfg, err := loadAWSConfig(ctx, opts, region)
+	if err != nil {
+		return nil, err
+	}
+
+	if creds, ok := clientCache.credentials[opts.profile]; ok {
+		cfg.Credentials = creds
+	} else {
+		clientCache.credentials[opts.profile] = cfg.Credentials
+	}
+
+	if role != "" {
+		cfg.Credentials = aws.NewCredentialsCache(stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cfg), role))
+	}
+
+	c := &awsClients{
+		cfg:     cfg,
+		ec2:     ec2.NewFromConfig(cfg),
+		connect: ec2instanceconnect.NewFromConfig(cfg),
+	}
+	clientCache.clients[key] = c
+
+	return c, nil
+}
+
+// callerAccount returns the ID of the AWS account ec2-ssh operates as.
+func callerAccount(ctx context.Context, opts *toolOptions, region string) (string, error) {
+	clients, err := clientsFor(ctx, opts, region)
+	if err != nil {
+		return "", err
+	}
+
+	clients.accountOnce.Do(func() {
+		out, err := sts.NewFromConfig(clients.cfg).GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+		if err != nil {
+			clients.accountErr = err
+			return
+		}
+		clients.account = *out.Account
+	})
+
+	return clients.account, clients.accountErr
+}
+
+// clientsForInstance returns the clients to upload keys to the instance with.
+// An instance owned by another account, e.g. in a shared VPC, needs the role
+// for that account from the config file. Without account_roles there's no role
+// to assume, so the owner isn't checked and no STS call is made.
+func clientsForInstance(ctx context.Context, opts *toolOptions, instance *instanceInfo) (*awsClients, error) {
+	if instance.ownerID == "" || len(opts.fileConfig.AccountRoles) == 0 {
+		return clientsFor(ctx, opts, instance.region)
+	}
+
+	account, err := callerAccount(ctx, opts, instance.region)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get the AWS account: %w", err)
+	}
+
+	if account == instance.ownerID {
+		return clientsFor(ctx, opts, instance.region)
+	}
+
+	role := opts.fileConfig.AccountRoles[instance.ownerID]
+	if role == "" {
+		return nil, fmt.Errorf("the instance %s belongs to the AWS account %s, not %s which ec2-ssh operates as; "+
+			"set the role to assume for it in account_roles in the config file or use -profile with a profile for the owning account",
+			instance, instance.ownerID, account)
+	}
+
+	opts.logf("the instance %s belongs to the AWS account %s, assuming %s", instance, instance.ownerID, role)
+
+	return clientsAs(ctx, opts, instance.region, role)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"gopkg.in/yaml.v2"
+)
+
+// fileConfig is the ec2-ssh configuration file, by default
+// config.yaml in the ec2-ssh directory of the user's config directory.
+type fileConfig struct {
+	Profile       string      `yaml:"profile"`
+	Regions       []string    `yaml:"regions"`
+	DeriveOptions []string    `yaml:"derive_options"`
+	RegionHint    *regionHint `yaml:"region_hint"`
+	// Profiles holds the settings specific to AWS profiles, by profile name
+	Profiles map[string]profileConfig `yaml:"profiles"`
+	// AccountRoles are the roles to assume to upload keys to instances owned by other accounts, by account ID
+	AccountRoles map[string]string `yaml:"account_roles"`
+	// BastionTag is the tag of the instances naming the bastion to jump through, same as -bastion-tag
+	BastionTag string `yaml:"bastion_tag"`
+	// UserRules choose the user to log in as by the instance tags, the first matching rule wins
+	UserRules []userRule `yaml:"user_rules"`
+	// Aliases name instances as "<instance-id> @ <region>", see instanceAlias
+	Aliases map[string]string `yaml:"aliases"`
+
+	aliases map[string]instanceAlias
+}
+
+type userRule struct {
+	Tags map[string]string `yaml:"tags"`
+	User string            `yaml:"user"`
+}
+
+type profileConfig struct {
+	Regions []string `yaml:"regions"`
+}
+
+// regionHint extracts a region code from instance names, like usw2 from usw2-web-01,
+// and maps it to the region to look for the instance in first.
+type regionHint struct {
+	// Pattern's first group, or the whole match if it has no groups, is the region code
+	Pattern string            `yaml:"pattern"`
+	Regions map[string]string `yaml:"regions"`
+
+	pattern *regexp.Regexp
+}
+
+// region returns the region hinted by the name or an empty string.
+func (h *regionHint) region(name string) string {
+	if h == nil || h.pattern == nil {
+		return ""
+	}
+
+	m := h.pattern.FindStringSubmatch(name)
+	if m == nil {
+		return ""
+	}
+
+	code := m[0]
+	if len(m) > 1 {
+		code = m[1]
+	}
+
+	return h.Regions[code]
+}
+
+func configFilePath() (string, error) {
+	if path := os.Getenv("EC2SSH_CONFIG"); path != "" {
+		return path, nil
+	}
+
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, "ec2-ssh", "config.yaml"), nil
+}
+
+// loadConfigFile returns an empty configuration if the file doesn't exist.
+func loadConfigFile() (*fileConfig, error) {
+	cfg := &fileConfig{}
+
+	path, err := configFilePath()
+	if err != nil {
+		return cfg, nil
+	}
+
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the config file: %w", err)
+	}
+
+	if err := yaml.UnmarshalStrict(content, cfg); err != nil {
+		return nil, fmt.Errorf("cannot parse the config file %s: %w", path, err)
+	}
+
+	for i, rule := range cfg.UserRules {
+		if len(rule.Tags) == 0 || !validUsername(rule.User) {
+			return nil, fmt.Errorf("invalid user rule %d in %s, it needs tags and a valid user", i+1, path)
+		}
+	}
+
+	cfg.aliases = map[string]instanceAlias{}
+	for name, value := range cfg.Aliases {
+		if cfg.aliases[name], err = parseAlias(value); err != nil {
+			return nil, fmt.Errorf("invalid alias %s in %s: %w", name, path, err)
+		}
+	}
+
+	if cfg.RegionHint != nil {
+		cfg.RegionHint.pattern, err = regexp.Compile(cfg.RegionHint.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid region_hint pattern in %s: %w", path, err)
+		}
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/ec2rolecreds"
+)
+
+// credentialSources are the values of -creds-order.
+var credentialSources = []string{"env", "sso", "instance", "profile"}
+
+var errNoCredentials = errors.New("no credentials")
+
+// credentialsInOrder returns the provider trying the credential sources
+// in the given order, using the first one that has credentials.
+func credentialsInOrder(opts *toolOptions, region string) aws.CredentialsProvider {
+	return aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+		var failures []string
+		for _, source := range opts.credsOrder {
+			creds, err := retrieveCredentials(ctx, opts, source, region)
+			if err == nil {
+				opts.logf("using the credentials from %s", source)
+				return creds, nil
+			}
+
+			failures = append(failures, fmt.Sprintf("%s: %s", source, err))
+		}
+
+		return aws.Credentials{}, fmt.Errorf("cannot find credentials in any of the sources of -creds-order: %s", strings.Join(failures, "; "))
+	})
+}
+
+func retrieveCredentials(ctx context.Context, opts *toolOptions, source, region string) (aws.Credentials, error) {
+	switch source {
+	case "env":
+		env, err := config.NewEnvConfig()
+		if err != nil {
+			return aws.Credentials{}, err
+		}
+		if !env.Credentials.HasKeys() {
+			return aws.Credentials{}, errNoCredentials
+		}
+		return env.Credentials, nil
+	case "instance":
+		return ec2rolecreds.New().Retrieve(ctx)
+	}
+
+	profile := opts.profile
+	if profile == "" {
+		profile = config.DefaultSharedConfigProfile
+	}
+
+	shared, err := config.LoadSharedConfigProfile(ctx, profile)
+	if err != nil {
+		return aws.Credentials{}, err
+	}
+
+	// SSO profiles are the sso source, every other kind is the profile one
+	sso := shared.SSOStartURL != ""
+	hasCreds := shared.Credentials.HasKeys() || shared.CredentialProcess != "" || shared.RoleARN != "" || shared.WebIdentityTokenFile != ""
+	if (source == "sso" && !sso) || (source == "profile" && (sso || !hasCreds)) {
+		return aws.Credentials{}, fmt.Errorf("the profile %s has no %s credentials", profile, source)
+	}
+
+	// with the profile set explicitly the SDK takes the credentials from it alone
+	optFns := append(clientOptions(opts), config.WithSharedConfigProfile(profile), config.WithRegion(region))
+	cfg, err := config.LoadDefaultConfig(ctx, optFns...)
+	if err != nil {
+		return aws.Credentials{}, err
+	}
+
+	return cfg.Credentials.Retrieve(ctx)
+}
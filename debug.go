@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/aws/smithy-go/logging"
+)
+
+// sensitiveHeaders matches the headers carrying credentials and signatures in
+// the logged requests.
+var sensitiveHeaders = regexp.MustCompile(`(?im)^((?:Authorization|X-Amz-Security-Token|X-Amz-Sso_bearer_token):[ \t]*)[^\r\n]*`)
+
+// sensitiveFields matches the credentials in the logged bodies: the XML of STS,
+// e.g. the AssumeRole responses, the JSON of SSO GetRoleCredentials and the
+// web identity token sent to STS.
+var sensitiveFields = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)(<(?:AccessKeyId|SecretAccessKey|SessionToken)>)[^<]*`),
+	regexp.MustCompile(`(?i)("(?:accessKeyId|secretAccessKey|sessionToken)"[ \t]*:[ \t]*")[^"]*`),
+	regexp.MustCompile(`(?i)(WebIdentityToken=)[^&\s]*`),
+}
+
+// redactingLogger hides the credentials from the SDK's request/response logs,
+// both in the headers and in the bodies.
+type redactingLogger struct {
+	logger logging.Logger
+}
+
+func (l redactingLogger) Logf(classification logging.Classification, format string, v ...interface{}) {
+	msg := sensitiveHeaders.ReplaceAllString(fmt.Sprintf(format, v...), "${1}[REDACTED]")
+	for _, field := range sensitiveFields {
+		msg = field.ReplaceAllString(msg, "${1}[REDACTED]")
+	}
+	l.logger.Logf(classification, "%s", msg)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// derivedOptions are the ssh options ec2-ssh can compute from the instance,
+// enabled with -derive-options or derive_options in the config file.
+var derivedOptions = []struct {
+	name  string
+	value func(instance *instanceInfo) string
+}{
+	// known_hosts entries follow the instance rather than its possibly reused IP
+	{"HostKeyAlias", func(instance *instanceInfo) string { return instance.instanceID }},
+}
+
+// derivedSSHOptions returns the -o arguments of the enabled derived options.
+func derivedSSHOptions(opts *toolOptions, instance *instanceInfo) ([]string, error) {
+	names := opts.deriveOptions
+	if len(names) == 0 {
+		names = opts.fileConfig.DeriveOptions
+	}
+
+	var args []string
+	for _, name := range names {
+		found := false
+		for _, o := range derivedOptions {
+			// ssh option names are case-insensitive
+			if strings.EqualFold(o.name, name) {
+				args = append(args, "-o", o.name+"="+o.value(instance))
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return nil, fmt.Errorf("unsupported derived ssh option %q, use one of: %s", name, strings.Join(derivedOptionNames(), ", "))
+		}
+	}
+
+	return args, nil
+}
+
+func derivedOptionNames() []string {
+	var names []string
+	for _, o := range derivedOptions {
+		names = append(names, o.name)
+	}
+
+	return names
+}
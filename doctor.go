@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2instanceconnect"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/smithy-go"
+)
+
+type checkResult struct {
+	name   string
+	detail string
+	err    error
+	hint   string
+}
+
+// doctor runs a set of self-diagnostic checks and prints a checklist
+// with remediation hints for every failed check.
+func doctor(ctx context.Context, w io.Writer, args []string) error {
+	opts, _, err := parseArgs(args)
+	if err != nil {
+		return err
+	}
+
+	scan := resolveRegions(ctx, opts)
+
+	results := []checkResult{
+		checkSSHBinary(ctx),
+		checkSSHKey(ctx, opts),
+		checkProxy(scan[0]),
+	}
+
+	cfg, err := loadAWSConfig(ctx, opts, scan[0])
+	if err != nil {
+		results = append(results, checkResult{
+			name: "AWS config",
+			err:  err,
+			hint: "check your ~/.aws/config and ~/.aws/credentials files",
+		})
+	} else {
+		results = append(results, checkCredentials(ctx, cfg))
+		results = append(results, checkSendSSHPublicKey(ctx, cfg))
+
+		for _, region := range scan {
+			results = append(results, checkRegion(ctx, cfg, region))
+		}
+	}
+
+	failed := 0
+	for _, res := range results {
+		if res.err != nil {
+			failed++
+			fmt.Fprintf(w, "[FAIL] %s: %s\n", res.name, res.err)
+			if res.hint != "" {
+				fmt.Fprintf(w, "       hint: %s\n", res.hint)
+			}
+			continue
+		}
+
+		fmt.Fprintf(w, "[ OK ] %s: %s\n", res.name, res.detail)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d checks failed", failed, len(results))
+	}
+
+	return nil
+}
+
+func checkSSHBinary(ctx context.Context) checkResult {
+	res := checkResult{name: "ssh binary"}
+
+	path, err := exec.LookPath("ssh")
+	if err != nil {
+		res.err = err
+		res.hint = "install the OpenSSH client and make sure `ssh` is in your PATH"
+		return res
+	}
+
+	// ssh prints its version to stderr
+	out, err := exec.CommandContext(ctx, path, "-V").CombinedOutput()
+	if err != nil {
+		res.err = fmt.Errorf("cannot get the ssh version: %w", err)
+		res.hint = "make sure the `ssh` in your PATH is the OpenSSH client"
+		return res
+	}
+
+	res.detail = fmt.Sprintf("%s (%s)", strings.TrimSpace(string(out)), path)
+	return res
+}
+
+func checkSSHKey(ctx context.Context, opts *toolOptions) checkResult {
+	res := checkResult{
+		name: "ssh key",
+		hint: "generate a key with `ssh-keygen` or point to an existing one with `IdentityFile` in your ssh config",
+	}
+
+	options, err := sshOptions(ctx, opts, []string{"localhost"})
+	if err != nil {
+		res.err = err
+		return res
+	}
+
+	pk, err := existingKey(options["identityfile"])
+	if err != nil {
+		res.err = err
+		return res
+	}
+
+	if _, err := getPublicKey(pk); err != nil {
+		res.err = fmt.Errorf("cannot read the public key %s.pub", pk)
+		return res
+	}
+
+	res.detail = pk
+	return res
+}
+
+// checkProxy shows which proxy, if any, the AWS API calls go through.
+func checkProxy(region string) checkResult {
+	res := checkResult{name: "AWS API proxy"}
+
+	req, err := http.NewRequest(http.MethodGet, "https://ec2."+region+".amazonaws.com/", nil)
+	if err != nil {
+		res.err = err
+		return res
+	}
+
+	proxy, err := http.ProxyFromEnvironment(req)
+	if err != nil {
+		res.err = err
+		res.hint = "fix the HTTPS_PROXY environment variable"
+		return res
+	}
+
+	res.detail = "none"
+	if proxy != nil {
+		res.detail = proxy.Redacted()
+	}
+
+	return res
+}
+
+func checkCredentials(ctx context.Context, cfg aws.Config) checkResult {
+	res := checkResult{name: "AWS credentials"}
+
+	out, err := sts.NewFromConfig(cfg).GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		res.err = err
+		res.hint = "configure credentials with `aws configure`, `aws sso login` or the AWS_PROFILE environment variable"
+		return res
+	}
+
+	res.detail = *out.Arn
+	return res
+}
+
+// checkSendSSHPublicKey verifies the permission to upload keys. EC2 Instance Connect
+// doesn't support dry runs so we upload to an instance that cannot exist.
+// Anything other than access denied means we're allowed to call the API.
+func checkSendSSHPublicKey(ctx context.Context, cfg aws.Config) checkResult {
+	res := checkResult{name: "ec2-instance-connect:SendSSHPublicKey"}
+
+	// the zone is required, the SDK doesn't send the request without it
+	_, err := ec2instanceconnect.NewFromConfig(cfg).SendSSHPublicKey(ctx, &ec2instanceconnect.SendSSHPublicKeyInput{
+		AvailabilityZone: strp(cfg.Region + "a"),
+		InstanceId:       strp("i-00000000000000000"),
+		InstanceOSUser:   strp("ec2-user"),
+		SSHPublicKey:     strp("ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIA== doctor"),
+	})
+
+	if boundaryDenied(err) {
+		res.err = err
+		res.hint = "your permissions boundary blocks ec2-instance-connect:SendSSHPublicKey, the boundary policy has to allow it"
+		return res
+	}
+	if isAPIError(err, "AccessDeniedException") {
+		res.err = err
+		res.hint = "grant the ec2-instance-connect:SendSSHPublicKey permission to your IAM identity"
+		return res
+	}
+
+	// any other API error, e.g. about the made-up instance, comes after the authorization
+	var apiErr smithy.APIError
+	if err != nil && !errors.As(err, &apiErr) {
+		res.err = err
+		res.hint = "check your network connection and whether EC2 Instance Connect is available in the region"
+		return res
+	}
+
+	res.detail = "allowed"
+	return res
+}
+
+func checkRegion(ctx context.Context, cfg aws.Config, region string) checkResult {
+	res := checkResult{name: "ec2:DescribeInstances in " + region}
+
+	cfg = cfg.Copy()
+	cfg.Region = region
+
+	_, err := ec2.NewFromConfig(cfg).DescribeInstances(ctx, &ec2.DescribeInstancesInput{
+		DryRun: aws.Bool(true),
+	})
+
+	switch {
+	case isAPIError(err, "DryRunOperation"):
+		res.detail = "allowed"
+	case boundaryDenied(err):
+		res.err = err
+		res.hint = "your permissions boundary blocks ec2:DescribeInstances, the boundary policy has to allow it"
+	case isAPIError(err, "UnauthorizedOperation"):
+		res.err = err
+		res.hint = "grant the ec2:DescribeInstances permission to your IAM identity"
+	case err != nil:
+		res.err = err
+		res.hint = "check your network connection and whether the region is enabled for your account"
+	default:
+		res.detail = "allowed"
+	}
+
+	return res
+}
+
+func isAPIError(err error, code string) bool {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.ErrorCode() == code
+	}
+
+	return false
+}
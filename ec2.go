@@ -2,54 +2,442 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net"
+	"net/http"
 	"os"
 	"os/exec"
+	"regexp"
+	"strings"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
 	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
 	"github.com/aws/aws-sdk-go-v2/service/ec2instanceconnect"
+	"github.com/aws/smithy-go"
+	"github.com/aws/smithy-go/logging"
 )
 
-func instanceInfoFromString(hostname, user string) (*instanceInfo, error) {
+var instanceIDRegexp = regexp.MustCompile(`^i-[0-9a-f]{8}([0-9a-f]{9})?$`)
+
+// publicDNSRegexp matches the EC2 public DNS names, e.g. ec2-203-0-113-25.us-west-2.compute.amazonaws.com
+// or ec2-203-0-113-25.compute-1.amazonaws.com in us-east-1.
+var publicDNSRegexp = regexp.MustCompile(`^ec2-[0-9-]+\.(([a-z0-9-]+)\.compute|compute-1)\.amazonaws\.com$`)
+
+// publicDNSRegion returns the region of the EC2 public DNS name.
+func publicDNSRegion(name string) string {
+	m := publicDNSRegexp.FindStringSubmatch(name)
+	if m == nil {
+		return ""
+	}
+	if m[2] == "" {
+		return "us-east-1"
+	}
+
+	return m[2]
+}
+
+func instanceInfoFromString(ctx context.Context, opts *toolOptions, hostname, user string) (*instanceInfo, error) {
 	info := &instanceInfo{
 		username: user,
 		host:     hostname,
 	}
 
-	err := info.resolveIP()
+	if isInstanceID(hostname) {
+		info.instanceID = hostname
+		info.connectIP = true
+		return info, nil
+	}
+
+	// an IP needs no lookup, which may fail or be slow
+	if ip := net.ParseIP(hostname); ip != nil {
+		info.ipAddress = ip.String()
+		info.publicIP = isPublicIPv4(ip)
+		return info, nil
+	}
+
+	info.byHostname = true
+
+	if opts.route53Zone != "" {
+		ip, err := resolveRoute53(ctx, opts, hostname)
+		if err != nil {
+			return nil, err
+		}
+
+		// the client may not resolve the record so ssh gets the IP
+		info.ipAddress = ip
+		info.connectIP = true
+		return info, nil
+	}
+
+	var err error
+	for i, resolver := range opts.resolvers() {
+		if err = info.resolveIP(resolver); err == nil {
+			info.publicIP = isPublicIPv4(net.ParseIP(info.ipAddress))
+			// ssh resolves with the system resolver so give it the IP
+			info.connectIP = opts.dnsServer != "" && i == 0
+			break
+		}
+		opts.logf("cannot resolve %s: %s", hostname, err)
+	}
 	if err != nil {
-		return nil, err
+		info.name = hostname
+		info.connectIP = true
 	}
 	return info, nil
 }
 
-func (info *instanceInfo) resolveIP() error {
-	resolver := net.Resolver{}
+func isInstanceID(s string) bool {
+	return instanceIDRegexp.MatchString(s)
+}
+
+func (info *instanceInfo) String() string {
+	switch {
+	case info.instanceID != "":
+		return info.instanceID
+	case info.name != "":
+		return info.name
+	case info.byTags:
+		return "instance with the given tags"
+	default:
+		return info.ipAddress
+	}
+}
+
+// displayName returns the destination as typed by the user along with
+// the host it points to if ssh config rewrites it with HostName.
+func (info *instanceInfo) displayName() string {
+	if info.alias == "" || info.alias == info.host {
+		return info.host
+	}
+
+	return fmt.Sprintf("%s (%s)", info.alias, info.host)
+}
+
+// filters returns the filters matching the instance, looked up by name only in the given states.
+func (info *instanceInfo) filters(states []string) []types.Filter {
+	switch {
+	case info.instanceID != "":
+		return []types.Filter{
+			{
+				Name:   strp("instance-id"),
+				Values: []string{info.instanceID},
+			},
+		}
+	case info.name != "":
+		name := info.name
+		if info.namePrefix {
+			name += "*"
+		}
+
+		return []types.Filter{
+			{
+				Name:   strp("tag:Name"),
+				Values: []string{name},
+			},
+			{
+				Name:   strp("instance-state-name"),
+				Values: states,
+			},
+		}
+	case info.byTags:
+		// the tags are among the options' filters
+		return []types.Filter{
+			{
+				Name:   strp("instance-state-name"),
+				Values: states,
+			},
+		}
+	case info.publicDNS != "":
+		return []types.Filter{
+			{
+				Name:   strp("dns-name"),
+				Values: []string{info.publicDNS},
+			},
+		}
+	case info.publicIP:
+		return []types.Filter{
+			{
+				Name:   strp("ip-address"),
+				Values: []string{info.ipAddress},
+			},
+		}
+	default:
+		// any of the private IPs, the DNS may point to a secondary one
+		return []types.Filter{
+			{
+				Name:   strp("network-interface.addresses.private-ip-address"),
+				Values: []string{info.ipAddress},
+			},
+		}
+	}
+}
+
+// instanceStates returns the states an instance can be in to be connected to.
+func (opts *toolOptions) instanceStates() []string {
+	states := []string{string(types.InstanceStateNameRunning)}
+	if opts.start {
+		states = append(states, string(types.InstanceStateNameStopped))
+	}
+
+	return states
+}
+
+// filters returns the filters narrowing down the instances that can match.
+func (opts *toolOptions) filters() []types.Filter {
+	var filters []types.Filter
+	if len(opts.vpcs) > 0 {
+		filters = append(filters, types.Filter{
+			Name:   strp("vpc-id"),
+			Values: opts.vpcs,
+		})
+	}
+
+	if opts.placementGroup != "" {
+		filters = append(filters, types.Filter{
+			Name:   strp("placement-group-name"),
+			Values: []string{opts.placementGroup},
+		})
+	}
+
+	if opts.dedicatedHost != "" {
+		filters = append(filters, types.Filter{
+			Name:   strp("host-id"),
+			Values: []string{opts.dedicatedHost},
+		})
+	}
+
+	for _, tag := range opts.tags {
+		parts := strings.SplitN(tag, "=", 2)
+		filters = append(filters, types.Filter{
+			Name:   strp("tag:" + parts[0]),
+			Values: []string{parts[1]},
+		})
+	}
+
+	return filters
+}
+
+func (info *instanceInfo) matches(inst types.Instance) bool {
+	switch {
+	case info.instanceID != "":
+		return *inst.InstanceId == info.instanceID
+	case info.name != "", info.byTags:
+		// already filtered by the tags
+		return true
+	case info.publicDNS != "":
+		return inst.PublicDnsName != nil && *inst.PublicDnsName == info.publicDNS
+	case info.publicIP:
+		return inst.PublicIpAddress != nil && *inst.PublicIpAddress == info.ipAddress
+	default:
+		return contains(privateIPs(inst), info.ipAddress)
+	}
+}
+
+// checkName makes sure the instance carries the requested name in its Name tag,
+// so a recycled IP or a loose name match doesn't lead to another instance.
+func (info *instanceInfo) checkName(inst types.Instance) error {
+	name := tagValue(inst, "Name")
+	if name == info.host || name == info.alias {
+		return nil
+	}
+
+	return fmt.Errorf("the instance %s matching %s is named %q, not %s; not connecting because of -strict-host", *inst.InstanceId, info.displayName(), name, info.host)
+}
+
+// checkResolvedIPs makes sure all the IPs the host resolves to belong to the instance,
+// otherwise the DNS is misconfigured or the IP is reused in another VPC.
+func (info *instanceInfo) checkResolvedIPs(inst types.Instance) error {
+	ips := privateIPs(inst)
+	if inst.PublicIpAddress != nil {
+		ips = append(ips, *inst.PublicIpAddress)
+	}
+
+	for _, ip := range info.resolvedIPs {
+		if !contains(ips, ip) {
+			return fmt.Errorf("%s resolves to %s too, which is not an IP of the instance %s it matched; check the DNS records or overlapping VPCs (-match-all-ips)",
+				info.displayName(), ip, *inst.InstanceId)
+		}
+	}
+
+	return nil
+}
+
+func (info *instanceInfo) resolveIP(resolver *net.Resolver) error {
 	ips, err := resolver.LookupIP(context.Background(), "ip", info.host)
 	if err != nil {
 		return err
 	}
 
+	// a friendly name may be a CNAME of the instance's public DNS name,
+	// the instance is matched by that name then as its private IP is unknown
+	if cname, err := resolver.LookupCNAME(context.Background(), info.host); err == nil {
+		cname = strings.TrimSuffix(cname, ".")
+		if cname != info.host && publicDNSRegexp.MatchString(cname) {
+			info.publicDNS = cname
+			info.region = publicDNSRegion(cname)
+		}
+	}
+
+	info.resolvedIPs = nil
 	for _, ip := range ips {
-		info.ipAddress = ip.String()
-		break
+		info.resolvedIPs = append(info.resolvedIPs, ip.String())
 	}
+	info.ipAddress = info.resolvedIPs[0]
 
 	return nil
 }
 
-func setupEC2Instance(ctx context.Context, instance *instanceInfo, publicKey, region string) (bool, error) {
-	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(region))
+// resolvers returns the resolvers to resolve the destination with, in order:
+// the one asking the -dns-server, followed by the system one unless disabled.
+func (opts *toolOptions) resolvers() []*net.Resolver {
+	if opts.dnsServer == "" {
+		return []*net.Resolver{net.DefaultResolver}
+	}
+
+	custom := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, opts.dnsServer)
+		},
+	}
+
+	if !opts.dnsFallback {
+		return []*net.Resolver{custom}
+	}
+
+	return []*net.Resolver{custom, net.DefaultResolver}
+}
+
+// privateRanges are the IPv4 ranges a VPC can use, any other IPv4 address
+// of an instance is a public one.
+var privateRanges = []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "100.64.0.0/10"}
+
+// isPublicIPv4 reports whether the IP is a public IPv4 address, which EC2 knows
+// as the public IP of an instance rather than one of its private IPs.
+func isPublicIPv4(ip net.IP) bool {
+	if ip == nil || ip.To4() == nil || !ip.IsGlobalUnicast() {
+		return false
+	}
+
+	for _, cidr := range privateRanges {
+		_, network, _ := net.ParseCIDR(cidr)
+		if network.Contains(ip) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// privateIPs returns all private IPs of the instance, the primary one first.
+func privateIPs(inst types.Instance) []string {
+	ips := []string{}
+	if inst.PrivateIpAddress != nil {
+		ips = append(ips, *inst.PrivateIpAddress)
+	}
+
+	for _, eni := range inst.NetworkInterfaces {
+		for _, addr := range eni.PrivateIpAddresses {
+			if addr.PrivateIpAddress != nil && !contains(ips, *addr.PrivateIpAddress) {
+				ips = append(ips, *addr.PrivateIpAddress)
+			}
+		}
+	}
+
+	return ips
+}
+
+// selectIP points ssh to the private IP chosen with -prefer-ip or -ip-index.
+func (info *instanceInfo) selectIP(opts *toolOptions) error {
+	switch {
+	case opts.preferIP != "":
+		if !contains(info.privateIPs, opts.preferIP) {
+			return fmt.Errorf("the instance %s has no private IP %s, available: %s", info, opts.preferIP, strings.Join(info.privateIPs, ", "))
+		}
+		info.ipAddress = opts.preferIP
+	case opts.ipIndex >= 0:
+		if opts.ipIndex >= len(info.privateIPs) {
+			return fmt.Errorf("the instance %s has only %d private IPs: %s", info, len(info.privateIPs), strings.Join(info.privateIPs, ", "))
+		}
+		info.ipAddress = info.privateIPs[opts.ipIndex]
+	default:
+		return nil
+	}
+
+	info.connectIP = true
+	return nil
+}
+
+func loadAWSConfig(ctx context.Context, opts *toolOptions, region string) (aws.Config, error) {
+	optFns := clientOptions(opts)
+	if region != "" {
+		optFns = append(optFns, config.WithRegion(region))
+	}
+	if opts.profile != "" {
+		optFns = append(optFns, config.WithSharedConfigProfile(opts.profile))
+	}
+
+	if len(opts.credsOrder) > 0 {
+		optFns = append(optFns, config.WithCredentialsProvider(aws.NewCredentialsCache(credentialsInOrder(opts, region))))
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx, optFns...)
 	if err != nil {
-		return false, fmt.Errorf("cannot get config for AWS: %w", err)
+		return aws.Config{}, err
+	}
+
+	if err := checkFIPSRegion(opts, cfg.Region); err != nil {
+		return aws.Config{}, err
+	}
+
+	return cfg, nil
+}
+
+// clientOptions are the options of every AWS config ec2-ssh loads, setting how
+// the clients reach and log the APIs.
+func clientOptions(opts *toolOptions) []func(*config.LoadOptions) error {
+	// the SDK's default client honors HTTPS_PROXY too, but we set it explicitly
+	// so API calls keep going through the proxy whatever the SDK defaults are
+	httpClient := awshttp.NewBuildableClient().WithTransportOptions(func(tr *http.Transport) {
+		tr.Proxy = http.ProxyFromEnvironment
+	})
+
+	optFns := []func(*config.LoadOptions) error{config.WithHTTPClient(httpClient)}
+	if opts.fips {
+		optFns = append(optFns, config.WithUseFIPSEndpoint(aws.FIPSEndpointStateEnabled))
+	}
+
+	if opts.debugAWS {
+		optFns = append(optFns,
+			config.WithClientLogMode(aws.LogRequestWithBody|aws.LogResponseWithBody),
+			config.WithLogger(redactingLogger{logger: logging.NewStandardLogger(os.Stderr)}),
+		)
 	}
 
-	client := ec2.NewFromConfig(cfg)
+	return optFns
+}
 
-	ec2Instance, err := findEC2Instance(ctx, client, instance)
+// describeEC2Instance looks for the instance in the region and fills in its details.
+func describeEC2Instance(ctx context.Context, opts *toolOptions, instance *instanceInfo, region string) (bool, error) {
+	clients, err := clientsFor(ctx, opts, region)
+	if err != nil {
+		return false, withKind(ErrRegionScanFailed, fmt.Errorf("cannot get config for AWS: %w", err))
+	}
+
+	client := clients.ec2
+
+	var ec2Instance *types.Instance
+	if opts.offline {
+		ec2Instance, err = findInSnapshot(opts, instance, region)
+	} else {
+		ec2Instance, err = findEC2Instance(ctx, client, opts, instance)
+	}
 	if err != nil {
 		return false, err
 	}
@@ -58,28 +446,217 @@ func setupEC2Instance(ctx context.Context, instance *instanceInfo, publicKey, re
 		return false, nil
 	}
 
-	status, err := instanceStatus(ctx, client, *ec2Instance)
+	opts.logf("found instance %s in %s", *ec2Instance.InstanceId, region)
+
+	if opts.strictHost && instance.byHostname {
+		if err := instance.checkName(*ec2Instance); err != nil {
+			return false, err
+		}
+	}
+
+	if opts.matchAllIPs {
+		if err := instance.checkResolvedIPs(*ec2Instance); err != nil {
+			return false, err
+		}
+	}
+
+	if opts.start && ec2Instance.State.Name == types.InstanceStateNameStopped {
+		ec2Instance, err = instance.start(ctx, client, opts, *ec2Instance)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if instance.ipAddress == "" {
+		instance.ipAddress = *ec2Instance.PrivateIpAddress
+	}
+
+	instance.privateIPs = privateIPs(*ec2Instance)
+
+	// sshd may not listen on the secondary IP the lookup matched
+	if instance.ipAddress != *ec2Instance.PrivateIpAddress && contains(instance.privateIPs, instance.ipAddress) && !opts.keepMatchedIP {
+		opts.logf("%s is a secondary IP of %s, connecting to the primary one %s", instance.ipAddress, *ec2Instance.InstanceId, *ec2Instance.PrivateIpAddress)
+		instance.ipAddress = *ec2Instance.PrivateIpAddress
+		instance.connectIP = true
+	}
+
+	if err := instance.selectIP(opts); err != nil {
+		return false, err
+	}
+
+	instance.availabilityZone, err = availabilityZone(ctx, client, opts, *ec2Instance)
+	if err != nil {
+		return false, err
+	}
+
+	instance.instanceID = *ec2Instance.InstanceId
+	instance.region = region
+	instance.details = ec2Instance
+
+	if opts.autoUser {
+		instance.userCandidates, err = userCandidates(ctx, client, *ec2Instance)
+		if err != nil {
+			return false, err
+		}
+
+		instance.username = instance.userCandidates[0]
+		opts.logf("guessed the user %s", instance.username)
+	}
+
+	if user := tagValue(*ec2Instance, userTag); user != "" && !instance.userGiven && !validUsername(user) {
+		fmt.Fprintf(os.Stderr, "ignoring the %s tag of %s, %q is not a valid user name\n", userTag, instance, user)
+	}
+
+	if user, source := tagUser(opts, *ec2Instance); user != "" && !instance.userGiven {
+		opts.logf("using the user %s from %s", user, source)
+		instance.username = user
+		instance.userFromTag = true
+		if opts.autoUser {
+			candidates := []string{user}
+			for _, c := range instance.userCandidates {
+				if c != user {
+					candidates = append(candidates, c)
+				}
+			}
+			instance.userCandidates = candidates
+		}
+	}
+
+	return true, nil
+}
+
+// setupEC2Instance uploads the public key to the described instance.
+func setupEC2Instance(ctx context.Context, opts *toolOptions, instance *instanceInfo, publicKey string) error {
+	if opts.readOnly {
+		opts.logf("read-only mode, not uploading the public key for %s", instance.username)
+		return nil
+	}
+
+	if instance.username == "" {
+		return withKind(ErrKeyUploadFailed, errors.New("could not determine SSH user (specify with -l or user@host)"))
+	}
+
+	clients, err := clientsForInstance(ctx, opts, instance)
 	if err != nil {
-		return false, fmt.Errorf("cannot get the instance status: %w", err)
+		return withKind(ErrKeyUploadFailed, err)
 	}
 
-	connect := ec2instanceconnect.NewFromConfig(cfg)
-	out, err := connect.SendSSHPublicKey(ctx, &ec2instanceconnect.SendSSHPublicKeyInput{
-		AvailabilityZone: status.AvailabilityZone,
-		InstanceId:       ec2Instance.InstanceId,
+	out, err := clients.connect.SendSSHPublicKey(ctx, &ec2instanceconnect.SendSSHPublicKeyInput{
+		AvailabilityZone: &instance.availabilityZone,
+		InstanceId:       &instance.instanceID,
 		InstanceOSUser:   &instance.username,
 		SSHPublicKey:     &publicKey,
 	})
 
+	if instanceConnectUnavailable(err) {
+		return withKind(ErrKeyUploadFailed, fmt.Errorf("EC2 Instance Connect is not available in %s; it may not be enabled for the region or your account, or its endpoint is unreachable: %w", instance.region, err))
+	}
+	if boundaryDenied(err) {
+		return withKind(ErrKeyUploadFailed, fmt.Errorf("your permissions boundary blocks ec2-instance-connect:SendSSHPublicKey, the boundary policy has to allow it: %w", err))
+	}
+	if isAPIError(err, "EC2InstanceNotFoundException") {
+		hint := ""
+		if instance.ownerID != "" && len(opts.fileConfig.AccountRoles) == 0 {
+			hint = fmt.Sprintf("; if it belongs to another account than yours, e.g. in a shared VPC, set the role for %s in account_roles in the config file", instance.ownerID)
+		}
+		return withKind(ErrKeyUploadFailed, fmt.Errorf("EC2 Instance Connect cannot find the instance %s in %s%s: %w", instance.instanceID, instance.region, hint, err))
+	}
 	if err != nil {
-		return false, fmt.Errorf("cannot upload the public key: %w", err)
+		return withKind(ErrKeyUploadFailed, fmt.Errorf("cannot upload the public key: %w", err))
 	}
 
 	if !out.Success {
-		return false, fmt.Errorf("unsuccessful uploaded the public key")
+		return withKind(ErrKeyUploadFailed, errors.New("unsuccessful uploaded the public key"))
 	}
 
-	return true, nil
+	opts.logf("uploaded the public key for %s", instance.username)
+
+	return nil
+}
+
+// instanceConnectUnavailable reports whether the error means that EC2 Instance Connect
+// itself cannot be used in the region rather than there's a problem with the instance.
+func instanceConnectUnavailable(err error) bool {
+	var dnsErr *net.DNSError
+	var opErr *net.OpError
+	if errors.As(err, &dnsErr) || errors.As(err, &opErr) {
+		return true
+	}
+
+	for _, code := range []string{"ServiceUnavailableException", "OptInRequired", "UnrecognizedClientException", "InvalidClientTokenId"} {
+		if isAPIError(err, code) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// boundaryDenied reports whether the call was denied by an IAM permissions boundary
+// rather than for the lack of a grant.
+func boundaryDenied(err error) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+
+	switch apiErr.ErrorCode() {
+	case "AccessDenied", "AccessDeniedException", "UnauthorizedOperation":
+		return strings.Contains(apiErr.ErrorMessage(), "permissions boundary")
+	}
+
+	return false
+}
+
+// notFoundHint suggests that the instance may belong to another account
+// than the one ec2-ssh operates as.
+func notFoundHint(ctx context.Context, opts *toolOptions, region string) string {
+	if opts.offline {
+		return "Searched the snapshot, run ec2-ssh snapshot if the instance is new"
+	}
+
+	account, err := callerAccount(ctx, opts, region)
+	if err != nil {
+		account = "unknown"
+	}
+
+	return fmt.Sprintf("Searched as AWS account %s; if the instance belongs to another account, use -profile with a profile for the owning account", account)
+}
+
+// availabilityZone returns the availability zone to upload the key to. With
+// -skip-status it's taken from -az or the instance's placement, otherwise from
+// its status, which also tells whether it's running.
+func availabilityZone(ctx context.Context, client *ec2.Client, opts *toolOptions, instance types.Instance) (string, error) {
+	if opts.skipStatus {
+		if instance.State.Name != types.InstanceStateNameRunning {
+			return "", fmt.Errorf("the instance %s is %s, use -start to start it", *instance.InstanceId, instance.State.Name)
+		}
+		if opts.az != "" {
+			return opts.az, nil
+		}
+		if instance.Placement != nil && aws.ToString(instance.Placement.AvailabilityZone) != "" {
+			return *instance.Placement.AvailabilityZone, nil
+		}
+		return "", fmt.Errorf("cannot tell the availability zone of %s without its status, provide it with -az", *instance.InstanceId)
+	}
+
+	status, err := instanceStatus(ctx, client, instance)
+	if err != nil {
+		return "", withKind(ErrRegionScanFailed, fmt.Errorf("cannot get the instance status: %w", err))
+	}
+
+	if opts.requireHealthy {
+		status, err = checkHealth(ctx, client, opts, instance, status)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if opts.az != "" && opts.az != *status.AvailabilityZone {
+		return "", fmt.Errorf("the instance %s is in %s, not in %s given with -az", *instance.InstanceId, *status.AvailabilityZone, opts.az)
+	}
+
+	return *status.AvailabilityZone, nil
 }
 
 func instanceStatus(ctx context.Context, client *ec2.Client, instance types.Instance) (types.InstanceStatus, error) {
@@ -91,38 +668,72 @@ func instanceStatus(ctx context.Context, client *ec2.Client, instance types.Inst
 		return types.InstanceStatus{}, err
 	}
 
+	// only running instances have a status
+	if len(descResp.InstanceStatuses) == 0 {
+		return types.InstanceStatus{}, fmt.Errorf("the instance %s is %s, use -start to start it", *instance.InstanceId, instance.State.Name)
+	}
+
 	status := descResp.InstanceStatuses[0]
 	return status, nil
 }
 
-func findEC2Instance(ctx context.Context, client *ec2.Client, info *instanceInfo) (*types.Instance, error) {
-	resp, err := client.DescribeInstances(ctx, &ec2.DescribeInstancesInput{
-		Filters: []types.Filter{
-			{
-				Name:   strp("private-ip-address"),
-				Values: []string{info.ipAddress},
-			},
-		},
+func findEC2Instance(ctx context.Context, client *ec2.Client, opts *toolOptions, info *instanceInfo) (*types.Instance, error) {
+	// the owner differs from the account of the reservation's requester in shared VPCs
+	owners := map[string]string{}
+	var matches []types.Instance
+	pages := ec2.NewDescribeInstancesPaginator(client, &ec2.DescribeInstancesInput{
+		Filters: append(info.filters(opts.instanceStates()), opts.filters()...),
 	})
+	for pages.HasMorePages() {
+		resp, err := pages.NextPage(ctx)
+		if boundaryDenied(err) {
+			return nil, withKind(ErrRegionScanFailed, fmt.Errorf("your permissions boundary blocks ec2:DescribeInstances, the boundary policy has to allow it: %w", err))
+		}
+		if err != nil {
+			return nil, withKind(ErrRegionScanFailed, fmt.Errorf("cannot contact with AWS API: %w", err))
+		}
 
-	if err != nil {
-		return nil, fmt.Errorf("cannot contact with AWS API: %w", err)
+		for _, r := range resp.Reservations {
+			for _, inst := range r.Instances {
+				if info.matches(inst) {
+					matches = append(matches, inst)
+					owners[*inst.InstanceId] = aws.ToString(r.OwnerId)
+				}
+			}
+		}
 	}
 
-	for _, r := range resp.Reservations {
-		for _, inst := range r.Instances {
-			if *inst.PrivateIpAddress == info.ipAddress {
-				return &inst, nil
-			}
+	ignoredCase := len(matches) == 0 && info.name != ""
+	if ignoredCase {
+		opts.logf("no instance named exactly %s, ignoring the case", info.name)
+		var err error
+		matches, err = findByNameIgnoringCase(ctx, client, opts, info, owners)
+		if err != nil {
+			return nil, withKind(ErrRegionScanFailed, fmt.Errorf("cannot contact with AWS API: %w", err))
 		}
 	}
-	return nil, nil
+
+	if len(matches) == 0 {
+		return nil, nil
+	}
+
+	inst, err := selectInstance(opts, matches)
+	if err != nil {
+		return nil, err
+	}
+
+	if ignoredCase {
+		noteCaseMatch(info, *inst)
+	}
+
+	info.ownerID = owners[*inst.InstanceId]
+	return inst, nil
 }
 
-func connectToInstance(ctx context.Context, params []string) error {
+func connectToInstance(ctx context.Context, params []string, stderr io.Writer) error {
 	cmd := exec.CommandContext(ctx, "ssh", params...)
 	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stdout
+	cmd.Stderr = stderr
 	cmd.Stdin = os.Stdin
 
 	if err := cmd.Run(); err != nil {
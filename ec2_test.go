@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+func TestDisplayName(t *testing.T) {
+	tests := []struct {
+		name string
+		info instanceInfo
+		want string
+	}{
+		{name: "no alias", info: instanceInfo{host: "10.0.0.5"}, want: "10.0.0.5"},
+		{name: "alias equal to the host", info: instanceInfo{host: "10.0.0.5", alias: "10.0.0.5"}, want: "10.0.0.5"},
+		{name: "alias rewritten by HostName", info: instanceInfo{host: "10.0.0.5", alias: "web"}, want: "web (10.0.0.5)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.info.displayName(); got != tt.want {
+				t.Errorf("displayName() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestHostNameFromSSHConfig checks the instance is matched by the HostName
+// ssh -G reports for the destination while the alias is only displayed.
+func TestHostNameFromSSHConfig(t *testing.T) {
+	if _, err := exec.LookPath("ssh"); err != nil {
+		t.Skip("ssh is not installed")
+	}
+
+	sshConfig := filepath.Join(t.TempDir(), "config")
+	content := "Host web\n  HostName 10.0.0.5\n  User ec2-user\n\nHost db\n  HostName i-0123456789abcdef0\n"
+	if err := os.WriteFile(sshConfig, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		alias       string
+		user        string
+		filter      string
+		value       string
+		displayName string
+	}{
+		{alias: "web", user: "ec2-user", filter: "network-interface.addresses.private-ip-address", value: "10.0.0.5", displayName: "web (10.0.0.5)"},
+		{alias: "db", filter: "instance-id", value: "i-0123456789abcdef0", displayName: "db (i-0123456789abcdef0)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.alias, func(t *testing.T) {
+			opts := &toolOptions{}
+			options, err := sshOptions(context.Background(), opts, []string{"-F", sshConfig, tt.alias})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			info, err := instanceInfoFromString(context.Background(), opts, options["hostname"][0], options["user"][0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			info.alias = tt.alias
+
+			filters := info.filters([]string{"running"})
+			if len(filters) != 1 {
+				t.Fatalf("filters() = %v, want one filter", filters)
+			}
+			if aws.ToString(filters[0].Name) != tt.filter || !reflect.DeepEqual(filters[0].Values, []string{tt.value}) {
+				t.Errorf("filters() = %s %v, want %s [%s]", aws.ToString(filters[0].Name), filters[0].Values, tt.filter, tt.value)
+			}
+			if tt.user != "" && info.username != tt.user {
+				t.Errorf("username = %q, want %q", info.username, tt.user)
+			}
+			if got := info.displayName(); got != tt.displayName {
+				t.Errorf("displayName() = %q, want %q", got, tt.displayName)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+)
+
+// instanceFromENI resolves the instance the network interface is attached to.
+// ssh connects to the interface's primary private IP.
+func instanceFromENI(ctx context.Context, opts *toolOptions, user string) (*instanceInfo, error) {
+	scan := resolveRegions(ctx, opts)
+	for _, region := range scan {
+		opts.logf("looking for %s in %s", opts.eni, region)
+
+		clients, err := clientsFor(ctx, opts, region)
+		if err != nil {
+			return nil, fmt.Errorf("cannot get config for AWS: %w", err)
+		}
+
+		resp, err := clients.ec2.DescribeNetworkInterfaces(ctx, &ec2.DescribeNetworkInterfacesInput{
+			NetworkInterfaceIds: []string{opts.eni},
+		})
+		if isAPIError(err, "InvalidNetworkInterfaceID.NotFound") {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot describe the network interface %s: %w", opts.eni, err)
+		}
+
+		if len(resp.NetworkInterfaces) == 0 {
+			continue
+		}
+
+		eni := resp.NetworkInterfaces[0]
+		if eni.Attachment == nil || eni.Attachment.InstanceId == nil {
+			return nil, fmt.Errorf("the network interface %s is not attached to an instance", opts.eni)
+		}
+
+		opts.logf("%s is attached to %s", opts.eni, *eni.Attachment.InstanceId)
+
+		return &instanceInfo{
+			username:   user,
+			host:       opts.eni,
+			ipAddress:  *eni.PrivateIpAddress,
+			instanceID: *eni.Attachment.InstanceId,
+			region:     region,
+			connectIP:  true,
+		}, nil
+	}
+
+	return nil, withKind(ErrNoInstanceFound, fmt.Errorf("cannot find the network interface %s in any of the regions: %s", opts.eni, strings.Join(scan, ", ")))
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// setenv sets the environment variable for the test, restoring it afterwards.
+func setenv(t *testing.T, key, value string) {
+	t.Helper()
+
+	old, ok := os.LookupEnv(key)
+	t.Cleanup(func() {
+		if ok {
+			_ = os.Setenv(key, old)
+		} else {
+			_ = os.Unsetenv(key)
+		}
+	})
+
+	if err := os.Setenv(key, value); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadEnvFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		preset  map[string]string
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name:    "plain values",
+			content: "EC2SSH_TEST_A=one\nEC2SSH_TEST_B = two \n",
+			want:    map[string]string{"EC2SSH_TEST_A": "one", "EC2SSH_TEST_B": "two"},
+		},
+		{
+			name:    "export prefix",
+			content: "export EC2SSH_TEST_A=one\n",
+			want:    map[string]string{"EC2SSH_TEST_A": "one"},
+		},
+		{
+			name:    "quoted values",
+			content: "EC2SSH_TEST_A=\"one two\"\nEC2SSH_TEST_B='a=b'\nEC2SSH_TEST_C=\"unbalanced'\n",
+			want:    map[string]string{"EC2SSH_TEST_A": "one two", "EC2SSH_TEST_B": "a=b", "EC2SSH_TEST_C": "\"unbalanced'"},
+		},
+		{
+			name:    "comments and empty lines",
+			content: "# profile\n\n   \n  # EC2SSH_TEST_B=two\nEC2SSH_TEST_A=one\n",
+			want:    map[string]string{"EC2SSH_TEST_A": "one", "EC2SSH_TEST_B": ""},
+		},
+		{
+			name:    "empty value",
+			content: "EC2SSH_TEST_A=\n",
+			want:    map[string]string{"EC2SSH_TEST_A": ""},
+		},
+		{
+			name:    "the environment wins",
+			content: "EC2SSH_TEST_A=one\nEC2SSH_TEST_B=two\n",
+			preset:  map[string]string{"EC2SSH_TEST_A": "from-env"},
+			want:    map[string]string{"EC2SSH_TEST_A": "from-env", "EC2SSH_TEST_B": "two"},
+		},
+		{
+			name:    "line without =",
+			content: "EC2SSH_TEST_A=one\nEC2SSH_TEST_B\n",
+			wantErr: true,
+		},
+		{
+			name:    "export without a value",
+			content: "export EC2SSH_TEST_A\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{"EC2SSH_TEST_A", "EC2SSH_TEST_B", "EC2SSH_TEST_C"} {
+				setenv(t, name, "")
+				_ = os.Unsetenv(name)
+			}
+			for name, value := range tt.preset {
+				setenv(t, name, value)
+			}
+
+			path := filepath.Join(t.TempDir(), ".env")
+			if err := os.WriteFile(path, []byte(tt.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			err := loadEnvFile(path)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("loadEnvFile() succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for name, want := range tt.want {
+				if got := os.Getenv(name); got != want {
+					t.Errorf("%s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadEnvFileMissing(t *testing.T) {
+	if err := loadEnvFile(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("loadEnvFile() succeeded, want an error")
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import "errors"
+
+// Kinds of errors callers can check with errors.Is. The returned errors
+// wrap their underlying cause, so errors.As works for it too.
+var (
+	ErrNoInstanceFound  = errors.New("no instance found")
+	ErrKeyUploadFailed  = errors.New("key upload failed")
+	ErrNoSSHKey         = errors.New("no ssh key")
+	ErrRegionScanFailed = errors.New("region scan failed")
+)
+
+// kindError is an error of one of the kinds above.
+type kindError struct {
+	kind error
+	err  error
+}
+
+func withKind(kind, err error) error {
+	return &kindError{kind: kind, err: err}
+}
+
+func (e *kindError) Error() string {
+	return e.err.Error()
+}
+
+func (e *kindError) Unwrap() error {
+	return e.err
+}
+
+func (e *kindError) Is(target error) bool {
+	return target == e.kind
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// printExports prints the details of the instance the arguments point to
+// as shell exports, to be used with eval.
+func printExports(ctx context.Context, opts *toolOptions, args []string, w io.Writer) error {
+	t, err := resolve(ctx, opts, args)
+	if err != nil {
+		return err
+	}
+
+	if !t.found {
+		return withKind(ErrNoInstanceFound, fmt.Errorf("%s is not an EC2 instance", t.instance.displayName()))
+	}
+
+	exports := []struct{ name, value string }{
+		{"EC2SSH_INSTANCE_ID", t.instance.instanceID},
+		{"EC2SSH_REGION", t.instance.region},
+		{"EC2SSH_AVAILABILITY_ZONE", t.instance.availabilityZone},
+		{"EC2SSH_PRIVATE_IP", t.instance.ipAddress},
+		{"EC2SSH_USER", t.instance.username},
+	}
+
+	for _, e := range exports {
+		// the values come from AWS and the ssh config so quote them for eval
+		fmt.Fprintf(w, "export %s=%s\n", e.name, shellQuote(e.value))
+	}
+
+	return nil
+}
+
+// writeInstanceJSON writes the full description of the instance to the file.
+func writeInstanceJSON(path string, inst *types.Instance) error {
+	out, err := json.MarshalIndent(inst, "", "  ")
+	if err != nil {
+		return fmt.Errorf("cannot encode the instance: %w", err)
+	}
+
+	if err := os.WriteFile(path, append(out, '\n'), 0o600); err != nil {
+		return fmt.Errorf("cannot write the instance to %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// connectionDetails are what an ssh client needs to connect to the authorized instance.
+type connectionDetails struct {
+	Host         string    `json:"host"`
+	Port         string    `json:"port"`
+	User         string    `json:"user"`
+	IdentityFile string    `json:"identity_file,omitempty"`
+	InstanceID   string    `json:"instance_id"`
+	Region       string    `json:"region"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+// emitConnection prints the connection details of the authorized instance as JSON
+// for other ssh clients. The uploaded key is accepted for 60 seconds only.
+func emitConnection(t *target, opts *toolOptions, w io.Writer) error {
+	if !t.found {
+		return withKind(ErrNoInstanceFound, fmt.Errorf("%s is not an EC2 instance", t.instance.displayName()))
+	}
+
+	host := t.instance.host
+	switch {
+	case opts.connectHost != "":
+		host = opts.connectHost
+	case t.instance.connectIP:
+		host = t.instance.ipAddress
+	}
+
+	out, err := json.MarshalIndent(connectionDetails{
+		Host:         host,
+		Port:         t.port,
+		User:         t.instance.username,
+		IdentityFile: t.identityFile,
+		InstanceID:   t.instance.instanceID,
+		Region:       t.instance.region,
+		ExpiresAt:    time.Now().Add(keyValidity).UTC(),
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(w, string(out))
+	return nil
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// fipsRegions are the regions with FIPS endpoints for EC2, SSM and STS,
+// as the SDK's endpoint metadata lists them. Elsewhere the FIPS endpoints
+// the SDK would derive don't exist.
+var fipsRegions = []string{"us-east-1", "us-east-2", "us-west-1", "us-west-2", "ca-central-1", "us-gov-east-1", "us-gov-west-1"}
+
+// checkFIPSRegion fails with -fips for a region without FIPS endpoints rather
+// than letting the API calls fail on a host that doesn't resolve.
+func checkFIPSRegion(opts *toolOptions, region string) error {
+	if !opts.fips || contains(fipsRegions, region) {
+		return nil
+	}
+
+	return fmt.Errorf("%s has no FIPS endpoints, -fips works only in %s", region, strings.Join(fipsRegions, ", "))
+}
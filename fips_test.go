@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2instanceconnect"
+	elbv2 "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// hostRecorder is an HTTP client recording the host of the request instead of sending it.
+type hostRecorder struct {
+	host string
+}
+
+func (r *hostRecorder) Do(req *http.Request) (*http.Response, error) {
+	r.host = req.URL.Host
+	return nil, errors.New("not sent")
+}
+
+func TestFIPSEndpoints(t *testing.T) {
+	setenv(t, "AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "missing"))
+	setenv(t, "AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "missing"))
+	setenv(t, "AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	setenv(t, "AWS_SECRET_ACCESS_KEY", "secret")
+
+	ctx := context.Background()
+	cfg, err := loadAWSConfig(ctx, &toolOptions{fips: true}, "us-east-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := &hostRecorder{}
+	cfg.HTTPClient = recorder
+	cfg.Retryer = func() aws.Retryer { return aws.NopRetryer{} }
+
+	tests := []struct {
+		service string
+		call    func() error
+		want    string
+	}{
+		{
+			service: "EC2",
+			call: func() error {
+				_, err := ec2.NewFromConfig(cfg).DescribeInstances(ctx, &ec2.DescribeInstancesInput{})
+				return err
+			},
+			want: "ec2-fips.us-east-1.amazonaws.com",
+		},
+		{
+			service: "EC2 Instance Connect",
+			call: func() error {
+				_, err := ec2instanceconnect.NewFromConfig(cfg).SendSSHPublicKey(ctx, &ec2instanceconnect.SendSSHPublicKeyInput{
+					InstanceId:     strp("i-0123456789abcdef0"),
+					InstanceOSUser: strp("ec2-user"),
+					SSHPublicKey:   strp("ssh-ed25519 AAAA"),
+				})
+				return err
+			},
+			want: "ec2-instance-connect-fips.us-east-1.amazonaws.com",
+		},
+		{
+			service: "SSM",
+			call: func() error {
+				_, err := ssm.NewFromConfig(cfg).GetParameter(ctx, &ssm.GetParameterInput{Name: strp("key")})
+				return err
+			},
+			want: "ssm-fips.us-east-1.amazonaws.com",
+		},
+		{
+			service: "STS",
+			call: func() error {
+				_, err := sts.NewFromConfig(cfg).GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+				return err
+			},
+			want: "sts-fips.us-east-1.amazonaws.com",
+		},
+		{
+			service: "Elastic Load Balancing v2",
+			call: func() error {
+				_, err := elbv2.NewFromConfig(cfg).DescribeTargetHealth(ctx, &elbv2.DescribeTargetHealthInput{TargetGroupArn: strp("arn")})
+				return err
+			},
+			want: "elasticloadbalancing-fips.us-east-1.amazonaws.com",
+		},
+		{
+			service: "Route 53",
+			call: func() error {
+				_, err := route53.NewFromConfig(cfg).ListHostedZones(ctx, &route53.ListHostedZonesInput{})
+				return err
+			},
+			want: "route53-fips.amazonaws.com",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.service, func(t *testing.T) {
+			recorder.host = ""
+			if err := tt.call(); err == nil {
+				t.Fatal("the call succeeded, want the recorder's error")
+			}
+
+			if recorder.host != tt.want {
+				t.Errorf("host = %q, want %q", recorder.host, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckFIPSRegion(t *testing.T) {
+	tests := []struct {
+		region  string
+		fips    bool
+		wantErr bool
+	}{
+		{region: "us-east-1", fips: true},
+		{region: "us-gov-west-1", fips: true},
+		{region: "eu-west-1", fips: true, wantErr: true},
+		{region: "eu-west-1"},
+	}
+
+	for _, tt := range tests {
+		err := checkFIPSRegion(&toolOptions{fips: tt.fips}, tt.region)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("checkFIPSRegion(%s) with -fips %v = %v, want an error: %v", tt.region, tt.fips, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,495 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// sshFlagsWithValue lists the ssh options that take an argument, see ssh(1).
+const sshFlagsWithValue = "BbcDEeFIiJLlmOopQRSWw"
+
+type toolOptions struct {
+	regions         []string
+	profile         string
+	verbose         bool
+	help            bool
+	version         bool
+	targetGroup     string
+	targetHealth    []string
+	ephemeral       bool
+	keyType         string
+	instanceID      string
+	autoUser        bool
+	debugAWS        bool
+	preferIP        string
+	ipIndex         int
+	tmux            bool
+	syncPanes       bool
+	newest          bool
+	connectHost     string
+	identityFromSSM string
+	start           bool
+	listUsers       bool
+	listAliases     bool
+	vpcs            []string
+	oldest          bool
+	socks           string
+	resolveOnly     bool
+	readOnly        bool
+	deriveOptions   []string
+	eni             string
+	serial          bool
+	noConnect       bool
+	export          bool
+	menu            bool
+	autoReconnect   int
+	retry           int
+	reconnectDelay  time.Duration
+	keepKeyFresh    time.Duration
+	matchPrefix     string
+	requireHealthy  bool
+	wait            bool
+	instanceJSON    string
+	probe           bool
+	keyscan         bool
+	chooseKey       bool
+	waitForInstance bool
+	interval        time.Duration
+	deadline        time.Duration
+	selectPolicy    string
+	taggingAPI      bool
+	logSSH          string
+	emitConnection  bool
+	profileRegion   bool
+	envFile         string
+	batch           bool
+	tags            []string
+	skipStatus      bool
+	az              string
+	dedicatedHost   string
+	placementGroup  string
+	credsOrder      []string
+	pkcs11          string
+	agentKey        bool
+	preConnect      string
+	preTimeout      time.Duration
+	maxAttempts     int
+	dnsServer       string
+	dnsFallback     bool
+	strictHost      bool
+	instKnownHosts  bool
+	bastionTag      string
+	offline         bool
+	matchAllIPs     bool
+	autoBatch       bool
+	keepMatchedIP   bool
+	summary         bool
+	quiet           bool
+	fips            bool
+	route53Zone     string
+	asgAll          string
+	mergeStderr     bool
+
+	fileConfig *fileConfig
+}
+
+func (opts *toolOptions) logf(format string, args ...interface{}) {
+	if opts.verbose {
+		fmt.Fprintf(os.Stderr, format+"\n", args...)
+	}
+}
+
+// listValue is a flag accepting a comma-separated list of values.
+type listValue []string
+
+func (l *listValue) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listValue) Set(value string) error {
+	*l = nil
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*l = append(*l, v)
+		}
+	}
+
+	return nil
+}
+
+func newFlagSet(opts *toolOptions) *flag.FlagSet {
+	fs := flag.NewFlagSet("ec2-ssh", flag.ContinueOnError)
+	fs.Var((*listValue)(&opts.regions), "region", "comma-separated list of AWS regions to look for the instance in")
+	fs.StringVar(&opts.envFile, "env-file", "", "dotenv file with environment variables like AWS_PROFILE or EC2SSH_REGIONS, the environment wins")
+	fs.BoolVar(&opts.fips, "fips", false, "call the FIPS endpoints of the AWS APIs")
+	fs.StringVar(&opts.profile, "profile", "", "AWS profile to use")
+	fs.Var((*listValue)(&opts.credsOrder), "creds-order", "comma-separated order of the credential sources to use instead of the AWS SDK's: "+strings.Join(credentialSources, ", "))
+	fs.BoolVar(&opts.profileRegion, "prefer-profile-region", false, "look in the region of the AWS profile before the one from AWS_REGION")
+	fs.BoolVar(&opts.batch, "batch", false, "never ask for anything, fail instead; also set with ssh's -o BatchMode=yes")
+	fs.BoolVar(&opts.autoBatch, "auto-batch", true, "turn -batch on when stdin is not a terminal, e.g. in CI, unless -o BatchMode is given")
+	fs.BoolVar(&opts.verbose, "verbose", false, "print what ec2-ssh does to stderr")
+	fs.StringVar(&opts.instanceID, "instance-id", "", "ID of the instance to authorize; ssh still connects to the destination")
+	fs.StringVar(&opts.matchPrefix, "match-prefix", "", "connect to an instance whose Name tag starts with this prefix")
+	fs.StringVar(&opts.eni, "eni", "", "ID of a network interface to connect to the instance it's attached to")
+	fs.StringVar(&opts.targetGroup, "target-group", "", "ARN of a load balancer target group to pick the instance from")
+	fs.Var((*listValue)(&opts.targetHealth), "target-health", "comma-separated list of target health states to pick from or all (default healthy)")
+	fs.BoolVar(&opts.probe, "probe", false, "upload the key only if ssh cannot log in without it")
+	fs.BoolVar(&opts.chooseKey, "choose-key", false, "ask which ssh key to upload when several are found, if run in a terminal")
+	fs.BoolVar(&opts.ephemeral, "ephemeral", false, "generate a throwaway key for this connection instead of using your ssh key")
+	fs.StringVar(&opts.pkcs11, "pkcs11", "", "PKCS#11 library, e.g. of a YubiKey, to read the public key to upload from; ssh authenticates with it too")
+	fs.BoolVar(&opts.agentKey, "agent-key", false, "upload a public key of the ssh agent instead of a local file")
+	fs.StringVar(&opts.identityFromSSM, "identity-from-ssm", "", "name of the SSM parameter holding the public key to upload instead of a local file")
+	fs.StringVar(&opts.keyType, "key-type", "ed25519", "algorithm of the ephemeral key: "+strings.Join(keyTypes, ", "))
+	fs.StringVar(&opts.preferIP, "prefer-ip", "", "private IP of the matched instance to connect to when it has several")
+	fs.BoolVar(&opts.keepMatchedIP, "keep-matched-ip", false, "connect to the secondary private IP the destination resolves to instead of the primary one")
+	fs.IntVar(&opts.ipIndex, "ip-index", -1, "index of the matched instance's private IP to connect to, 0 is the primary one")
+	fs.BoolVar(&opts.autoUser, "auto-user", false, "guess the OS user from the instance's AMI and retry with the next likely user on permission denied")
+	fs.BoolVar(&opts.readOnly, "read-only", false, "never change anything in AWS, ssh has to authenticate with a key already authorized on the instance")
+	fs.BoolVar(&opts.summary, "summary", false, "print the instance, user, duration and exit status to stderr when the session ends")
+	fs.BoolVar(&opts.quiet, "quiet", false, "don't print the -summary and the forwards, e.g. when -summary comes from an @file")
+	fs.BoolVar(&opts.mergeStderr, "merge-stderr", false, "send the stderr of ssh to stdout like older versions did; off by default so ssh's messages don't end up in the output of remote commands")
+	fs.BoolVar(&opts.mergeStderr, "merge-streams", false, "same as -merge-stderr")
+	fs.StringVar(&opts.logSSH, "log-ssh", "", "append the stderr of ssh to this file too, e.g. with -v for troubleshooting")
+	fs.BoolVar(&opts.debugAWS, "debug-aws", false, "log AWS API requests and responses to stderr, with credentials redacted")
+	fs.StringVar(&opts.connectHost, "connect-host", "", "address ssh connects to, independently of the instance being authorized")
+	fs.BoolVar(&opts.strictHost, "strict-host", false, "connect only if the Name tag of the instance is exactly the hostname it was found by")
+	fs.StringVar(&opts.route53Zone, "route53-zone", "", "ID of the Route 53 hosted zone to look the hostname up in with the API instead of DNS, e.g. a private one")
+	fs.StringVar(&opts.dnsServer, "dns-server", "", "DNS server to resolve the destination with, e.g. the VPC's 10.0.0.2, instead of the system resolver")
+	fs.BoolVar(&opts.dnsFallback, "dns-fallback", true, "resolve with the system resolver when the -dns-server fails")
+	fs.StringVar(&opts.bastionTag, "bastion-tag", "", "tag of the instances naming the [user@]host[:port] to jump through, like bastion_tag in the config file")
+	fs.StringVar(&opts.socks, "socks", "", "host:port of a SOCKS5 proxy ssh connects through, using nc")
+	fs.Var((*listValue)(&opts.deriveOptions), "derive-options", "comma-separated list of ssh options to set from the instance: "+strings.Join(derivedOptionNames(), ", "))
+	fs.BoolVar(&opts.taggingAPI, "tagging-api", false, "find the regions of an instance looked up by Name with the Resource Groups Tagging API first")
+	fs.Var((*listValue)(&opts.tags), "tag", "comma-separated list of key=value tags the instance has to have, it's chosen by them alone unless another way to find it is given")
+	fs.StringVar(&opts.dedicatedHost, "dedicated-host", "", "ID of the dedicated host the instance has to run on")
+	fs.StringVar(&opts.dedicatedHost, "host-id", "", "same as -dedicated-host")
+	fs.StringVar(&opts.placementGroup, "placement-group", "", "name of the placement group the instance has to be in")
+	fs.Var((*listValue)(&opts.vpcs), "vpc", "comma-separated list of VPC IDs the instance has to be in")
+	fs.StringVar(&opts.selectPolicy, "select", "", "how to choose when several instances match instead of asking: "+strings.Join(selectPolicies, ", "))
+	fs.BoolVar(&opts.newest, "newest", false, "connect to the most recently launched instance when several match, same as -select newest")
+	fs.BoolVar(&opts.oldest, "oldest", false, "connect to the earliest launched instance when several match, same as -select oldest")
+	fs.BoolVar(&opts.serial, "serial", false, "connect to the serial console of the instance, e.g. when its network is broken")
+	fs.BoolVar(&opts.start, "start", false, "start the instance if it's stopped and wait until it's running")
+	fs.BoolVar(&opts.resolveOnly, "resolve-only", false, "print the IP of the instance, or its ID if it has none, and exit without connecting")
+	fs.BoolVar(&opts.noConnect, "no-connect", false, "upload the public key and exit without connecting, with a non-zero status if it failed")
+	fs.BoolVar(&opts.export, "export", false, "print the instance details as shell exports and exit without connecting")
+	fs.BoolVar(&opts.matchAllIPs, "match-all-ips", false, "fail if not all the IPs the hostname resolves to belong to the matched instance")
+	fs.BoolVar(&opts.offline, "offline", false, "find the instance in the inventory saved by ec2-ssh snapshot instead of asking EC2")
+	fs.BoolVar(&opts.skipStatus, "skip-status", false, "don't ask EC2 for the instance status, saving a round trip; the availability zone comes from the instance or -az")
+	fs.StringVar(&opts.az, "az", "", "availability zone of the instance to upload the key to, e.g. with -skip-status")
+	fs.StringVar(&opts.preConnect, "pre-connect", "", "shell command to run before looking the instance up, e.g. to bring a VPN up; ec2-ssh fails if it does")
+	fs.DurationVar(&opts.preTimeout, "pre-connect-timeout", time.Minute, "how long the -pre-connect command may run")
+	fs.BoolVar(&opts.requireHealthy, "require-healthy", false, "connect only if the system and instance status checks passed")
+	fs.BoolVar(&opts.wait, "wait", false, "wait for the status checks to pass with -require-healthy")
+	fs.StringVar(&opts.instanceJSON, "output-instance-json", "", "write the full description of the matched instance as JSON to this file")
+	fs.BoolVar(&opts.waitForInstance, "wait-for-instance", false, "look for the instance again until it appears, e.g. when it's just being launched")
+	fs.DurationVar(&opts.interval, "interval", 5*time.Second, "time between the lookups with -wait-for-instance")
+	fs.DurationVar(&opts.deadline, "deadline", 2*time.Minute, "how long to wait for the instance with -wait-for-instance")
+	fs.BoolVar(&opts.emitConnection, "emit-connection", false, "upload the key and print the connection details as JSON for another ssh client, without connecting")
+	fs.BoolVar(&opts.listUsers, "list-users", false, "print the likely OS users of the instance and exit")
+	fs.BoolVar(&opts.listAliases, "list-aliases", false, "print the aliases from the config file, marking the stale ones, and exit")
+	fs.BoolVar(&opts.menu, "menu", false, "choose which of the destinations given to connect to")
+	fs.IntVar(&opts.autoReconnect, "autoreconnect", 0, "number of times to upload the key again and reconnect when the connection drops")
+	fs.IntVar(&opts.retry, "retry", 0, "number of times to upload the key again and retry when ssh cannot connect, e.g. on a network blip")
+	fs.IntVar(&opts.maxAttempts, "max-attempts", 3, "maximum number of times ssh is run, counting the reconnects, retries and the users tried with -auto-user")
+	fs.DurationVar(&opts.reconnectDelay, "reconnect-delay", 3*time.Second, "time to wait before reconnecting")
+	fs.DurationVar(&opts.keepKeyFresh, "keep-key-fresh", 0, "keep uploading the key every 50s for this long while ssh connects, for slow handshakes")
+	fs.BoolVar(&opts.instKnownHosts, "instance-known-hosts", false, "keep the host keys of every instance in its own known_hosts file, avoiding conflicts when IPs are reused")
+	fs.BoolVar(&opts.keyscan, "keyscan", false, "trust the instance's host keys from ssh-keyscan instead of asking to confirm them")
+	fs.StringVar(&opts.asgAll, "asg-all", "", "upload the key to every instance of the Auto Scaling group and run the remote command, if any, on all of them")
+	fs.BoolVar(&opts.tmux, "tmux", false, "connect to every destination given in a tmux pane")
+	fs.BoolVar(&opts.syncPanes, "sync-panes", false, "synchronize the input of the tmux panes")
+	fs.BoolVar(&opts.version, "version", false, "print the version of ec2-ssh, Go and the AWS SDK")
+	fs.BoolVar(&opts.help, "help", false, "print this help")
+	fs.BoolVar(&opts.help, "h", false, "print this help")
+
+	return fs
+}
+
+// parseArgs splits the arguments into ec2-ssh options and the arguments
+// that are passed through to ssh. ec2-ssh options can be prefixed with
+// one or two dashes and are recognized only before the destination.
+func parseArgs(args []string) (*toolOptions, []string, error) {
+	opts := &toolOptions{
+		targetHealth: []string{"healthy"},
+	}
+	fs := newFlagSet(opts)
+	fs.SetOutput(io.Discard)
+
+	own, rest := splitArgs(fs, args)
+	if err := fs.Parse(own); err != nil {
+		return nil, nil, err
+	}
+
+	if err := opts.validate(); err != nil {
+		return nil, nil, err
+	}
+
+	// the env file may point to the config file too
+	if opts.envFile != "" {
+		if err := loadEnvFile(opts.envFile); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	cfg, err := loadConfigFile()
+	if err != nil {
+		return nil, nil, err
+	}
+	opts.fileConfig = cfg
+	opts.profile = resolveProfile(opts.profile, cfg)
+
+	return opts, rest, nil
+}
+
+// resolveProfile returns the AWS profile to use, the first set of:
+//  1. the -profile flag
+//  2. the AWS_PROFILE environment variable
+//  3. profile from the config file
+//
+// An empty profile leaves the choice to the AWS SDK, i.e. the default profile.
+func resolveProfile(flag string, cfg *fileConfig) string {
+	for _, profile := range []string{flag, os.Getenv("AWS_PROFILE"), cfg.Profile} {
+		if profile != "" {
+			return profile
+		}
+	}
+
+	return ""
+}
+
+func (opts *toolOptions) validate() error {
+	keySources := 0
+	for _, set := range []bool{opts.ephemeral, opts.identityFromSSM != "", opts.pkcs11 != "", opts.agentKey} {
+		if set {
+			keySources++
+		}
+	}
+	if keySources > 1 {
+		return errors.New("only one of -ephemeral, -identity-from-ssm, -pkcs11 and -agent-key can be used")
+	}
+
+	if opts.readOnly && (opts.start || keySources > 0) {
+		return errors.New("-read-only cannot be used with -start, -ephemeral, -identity-from-ssm, -pkcs11 or -agent-key")
+	}
+
+	if opts.emitConnection && (opts.readOnly || opts.serial) {
+		return errors.New("-emit-connection cannot be used with -read-only or -serial")
+	}
+
+	// -probe may skip the very upload -no-connect checks
+	if opts.noConnect && (opts.readOnly || opts.probe) {
+		return errors.New("-no-connect cannot be used with -read-only or -probe")
+	}
+
+	if opts.probe && opts.ephemeral {
+		return errors.New("-probe and -ephemeral cannot be used together")
+	}
+
+	if opts.serial && (opts.readOnly || opts.autoUser) {
+		return errors.New("-serial cannot be used with -read-only or -auto-user")
+	}
+
+	if opts.maxAttempts < 1 {
+		return errors.New("-max-attempts has to be at least 1")
+	}
+
+	if opts.autoReconnect+opts.retry >= opts.maxAttempts {
+		return fmt.Errorf("-autoreconnect %d and -retry %d need -max-attempts of at least %d", opts.autoReconnect, opts.retry, opts.autoReconnect+opts.retry+1)
+	}
+
+	if (opts.autoReconnect > 0 || opts.retry > 0) && opts.autoUser {
+		return errors.New("-autoreconnect and -retry cannot be used with -auto-user")
+	}
+
+	if opts.wait && !opts.requireHealthy {
+		return errors.New("-wait can be used only with -require-healthy")
+	}
+
+	if opts.skipStatus && opts.requireHealthy {
+		return errors.New("-skip-status and -require-healthy cannot be used together")
+	}
+
+	// the Resource Groups Tagging API has no FIPS endpoints
+	if opts.fips && opts.taggingAPI {
+		return errors.New("-fips cannot be used with -tagging-api")
+	}
+
+	if opts.offline && (opts.start || opts.autoUser || opts.requireHealthy || opts.waitForInstance || opts.taggingAPI ||
+		opts.eni != "" || opts.targetGroup != "" || opts.asgAll != "") {
+		return errors.New("-offline cannot be used with -start, -auto-user, -require-healthy, -wait-for-instance, -tagging-api, -eni, -target-group or -asg-all")
+	}
+
+	// the snapshot tells the availability zone
+	if opts.offline {
+		opts.skipStatus = true
+	}
+
+	if opts.asgAll != "" && (opts.tmux || opts.menu) {
+		return errors.New("-asg-all cannot be used with -tmux or -menu")
+	}
+
+	if opts.menu && opts.tmux {
+		return errors.New("-menu and -tmux cannot be used together")
+	}
+
+	if opts.newest && opts.oldest {
+		return errors.New("-newest and -oldest cannot be used together")
+	}
+
+	if (opts.newest || opts.oldest) && opts.selectPolicy != "" {
+		return errors.New("-newest and -oldest cannot be used with -select")
+	}
+
+	switch {
+	case opts.newest:
+		opts.selectPolicy = "newest"
+	case opts.oldest:
+		opts.selectPolicy = "oldest"
+	}
+
+	if opts.selectPolicy != "" && !contains(selectPolicies, opts.selectPolicy) {
+		return fmt.Errorf("unsupported selection policy %q, use one of: %s", opts.selectPolicy, strings.Join(selectPolicies, ", "))
+	}
+
+	if opts.preferIP != "" && opts.ipIndex >= 0 {
+		return errors.New("-prefer-ip and -ip-index cannot be used together")
+	}
+
+	if opts.socks != "" {
+		if _, _, err := net.SplitHostPort(opts.socks); err != nil {
+			return fmt.Errorf("invalid SOCKS proxy address %q, use host:port", opts.socks)
+		}
+	}
+
+	if opts.dnsServer != "" {
+		if _, _, err := net.SplitHostPort(opts.dnsServer); err != nil {
+			opts.dnsServer = net.JoinHostPort(opts.dnsServer, "53")
+		}
+	}
+
+	if opts.eni != "" && !strings.HasPrefix(opts.eni, "eni-") {
+		return fmt.Errorf("invalid network interface ID %q", opts.eni)
+	}
+
+	for _, source := range opts.credsOrder {
+		if !contains(credentialSources, source) {
+			return fmt.Errorf("unknown credential source %q in -creds-order, use: %s", source, strings.Join(credentialSources, ", "))
+		}
+	}
+
+	if opts.dedicatedHost != "" && !strings.HasPrefix(opts.dedicatedHost, "h-") {
+		return fmt.Errorf("invalid dedicated host ID %q", opts.dedicatedHost)
+	}
+
+	for _, tag := range opts.tags {
+		if !strings.Contains(tag, "=") {
+			return fmt.Errorf("invalid tag %q, use key=value", tag)
+		}
+	}
+
+	sources := 0
+	for _, s := range []string{opts.eni, opts.targetGroup, opts.instanceID, opts.matchPrefix, opts.asgAll} {
+		if s != "" {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return errors.New("only one of -eni, -target-group, -instance-id, -match-prefix and -asg-all can be used")
+	}
+
+	if opts.instanceID != "" && !isInstanceID(opts.instanceID) {
+		return fmt.Errorf("invalid instance ID %q", opts.instanceID)
+	}
+
+	if !contains(keyTypes, opts.keyType) {
+		return fmt.Errorf("unsupported key type %q, use one of: %s", opts.keyType, strings.Join(keyTypes, ", "))
+	}
+
+	return nil
+}
+
+func splitArgs(fs *flag.FlagSet, args []string) (own []string, rest []string) {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "-" || !strings.HasPrefix(arg, "-") || arg == "--" {
+			// the destination and the remote command
+			return own, append(rest, args[i:]...)
+		}
+
+		parts := strings.SplitN(strings.TrimLeft(arg, "-"), "=", 2)
+		if f := fs.Lookup(parts[0]); f != nil {
+			own = append(own, arg)
+			if len(parts) == 1 && !isBoolFlag(f) && i+1 < len(args) {
+				i++
+				own = append(own, args[i])
+			}
+			continue
+		}
+
+		rest = append(rest, arg)
+		if sshFlagTakesValue(arg) && i+1 < len(args) {
+			i++
+			rest = append(rest, args[i])
+		}
+	}
+
+	return own, rest
+}
+
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// sshFlagTakesValue reports whether the next argument is the value of the given
+// ssh option. ssh options can be grouped (-vp 22) and the value can be glued
+// to the option (-p22).
+func sshFlagTakesValue(arg string) bool {
+	group := arg[1:]
+	for i, c := range group {
+		if strings.ContainsRune(sshFlagsWithValue, c) {
+			return i == len(group)-1
+		}
+	}
+
+	return false
+}
+
+// destinationIndex returns the index of the destination in the ssh arguments or -1 if there's none.
+func destinationIndex(args []string) int {
+	for i := 0; i < len(args); i++ {
+		if !strings.HasPrefix(args[i], "-") {
+			return i
+		}
+
+		if sshFlagTakesValue(args[i]) {
+			i++
+		}
+	}
+
+	return -1
+}
+
+func usage(w io.Writer) {
+	fs := newFlagSet(&toolOptions{})
+	fs.SetOutput(w)
+
+	fmt.Fprintln(w, "Usage: ec2-ssh [ec2-ssh options] [ssh options] [user@]hostname [command]")
+	fmt.Fprintln(w, "       ec2-ssh -tmux [ec2-ssh options] [ssh options] [user@]hostname...")
+	fmt.Fprintln(w, "       ec2-ssh -menu [ec2-ssh options] [ssh options] [user@]hostname...")
+	fmt.Fprintln(w, "       ec2-ssh doctor [ec2-ssh options]")
+	fmt.Fprintln(w, "       ec2-ssh permissions [ec2-ssh options]")
+	fmt.Fprintln(w, "       ec2-ssh snapshot [ec2-ssh options]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "ec2-ssh options:")
+	fs.PrintDefaults()
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "All other arguments are passed through to ssh, see ssh(1).")
+}
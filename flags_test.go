@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseArgsProfile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("profile: from-file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		args       []string
+		envProfile string
+		configFile string
+		want       string
+	}{
+		{
+			name:       "-profile beats AWS_PROFILE",
+			args:       []string{"-profile", "from-flag", "host"},
+			envProfile: "from-env",
+			configFile: path,
+			want:       "from-flag",
+		},
+		{
+			name:       "AWS_PROFILE beats the config file",
+			args:       []string{"host"},
+			envProfile: "from-env",
+			configFile: path,
+			want:       "from-env",
+		},
+		{
+			name:       "the profile of the config file",
+			args:       []string{"host"},
+			configFile: path,
+			want:       "from-file",
+		},
+		{
+			name: "the default profile",
+			args: []string{"host"},
+			want: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setenv(t, "AWS_PROFILE", tt.envProfile)
+			configFile := tt.configFile
+			if configFile == "" {
+				configFile = filepath.Join(t.TempDir(), "missing.yaml")
+			}
+			setenv(t, "EC2SSH_CONFIG", configFile)
+
+			opts, _, err := parseArgs(tt.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if opts.profile != tt.want {
+				t.Errorf("profile = %q, want %q", opts.profile, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseArgsRegionBeatsAWSRegion(t *testing.T) {
+	setenv(t, "AWS_REGION", "us-east-1")
+	setenv(t, "EC2SSH_REGIONS", "")
+	setenv(t, "EC2SSH_CONFIG", filepath.Join(t.TempDir(), "missing.yaml"))
+
+	opts, rest, err := parseArgs([]string{"-region", "eu-west-1,eu-central-1", "host"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(rest, []string{"host"}) {
+		t.Errorf("ssh arguments = %v, want [host]", rest)
+	}
+
+	got := resolveRegions(context.Background(), opts)
+	if want := []string{"eu-west-1", "eu-central-1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("resolveRegions() = %v, want %v", got, want)
+	}
+}
+
+func TestParseArgsConflicts(t *testing.T) {
+	setenv(t, "EC2SSH_CONFIG", filepath.Join(t.TempDir(), "missing.yaml"))
+
+	tests := [][]string{
+		{"-no-connect", "-probe", "host"},
+		{"-no-connect", "-read-only", "host"},
+		{"-probe", "-ephemeral", "host"},
+		{"-fips", "-tagging-api", "host"},
+	}
+
+	for _, args := range tests {
+		if _, _, err := parseArgs(args); err == nil {
+			t.Errorf("parseArgs(%v) succeeded, want an error", args)
+		}
+	}
+}
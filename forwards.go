@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// forwardKeepAlive is the ServerAliveInterval used for sessions with forwards,
+// so idle forwards aren't cut by NATs and firewalls.
+const forwardKeepAlive = "30"
+
+// checkForwards fails early if a local port of the -L or -D forwards, or the ones
+// from the ssh config, is already in use: ssh would notice only after connecting.
+// Unless quiet, ssh reminds of the forwards once connected.
+func (t *target) checkForwards(quiet bool) error {
+	local := append(append([]string{}, t.options["localforward"]...), t.options["dynamicforward"]...)
+	for _, forward := range local {
+		addr, ok := forwardAddress(forward)
+		if !ok {
+			continue
+		}
+
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			return fmt.Errorf("cannot forward %s, the local port is in use: %w", addr, err)
+		}
+		_ = l.Close()
+	}
+
+	if len(local)+len(t.options["remoteforward"]) == 0 {
+		return nil
+	}
+
+	if v := t.options["serveraliveinterval"]; len(v) == 0 || v[0] == "0" {
+		t.args = append([]string{"-o", "ServerAliveInterval=" + forwardKeepAlive}, t.args...)
+	}
+	if !quiet {
+		t.args = append([]string{"-o", "PermitLocalCommand=yes", "-o", "LocalCommand=" + t.reminderCommand()}, t.args...)
+	}
+
+	return nil
+}
+
+// reminderCommand returns the LocalCommand printing the forwards, ssh runs it
+// only after connecting. A LocalCommand of the ssh config still runs after it.
+func (t *target) reminderCommand() string {
+	var lines []string
+	for _, forward := range t.options["localforward"] {
+		lines = append(lines, fmt.Sprintf("forwarding %s to %s", forwardListen(forward), forwardTarget(forward)))
+	}
+	for _, forward := range t.options["dynamicforward"] {
+		lines = append(lines, fmt.Sprintf("SOCKS proxy at %s", forwardListen(forward)))
+	}
+	for _, forward := range t.options["remoteforward"] {
+		lines = append(lines, fmt.Sprintf("forwarding %s on the instance to %s", forwardListen(forward), forwardTarget(forward)))
+	}
+
+	command := escapeTokens(shellCommand(append([]string{"printf", `%s\n`}, lines...)) + " >&2")
+	if v := t.options["permitlocalcommand"]; len(v) > 0 && v[0] == "yes" {
+		if user := t.options["localcommand"]; len(user) > 0 {
+			command += "; " + strings.Join(user, " ")
+		}
+	}
+
+	return command
+}
+
+// forwardAddress returns the local address to bind of the forward as `ssh -G` prints
+// it, e.g. "8080 [localhost]:80" or "[127.0.0.1]:8080 [localhost]:80". Unix sockets and
+// ports chosen by ssh are skipped.
+func forwardAddress(forward string) (string, bool) {
+	listen := forwardListen(forward)
+	if strings.HasPrefix(listen, "/") {
+		return "", false
+	}
+
+	if _, err := strconv.Atoi(listen); err == nil {
+		listen = "localhost:" + listen
+	}
+
+	host, port, err := net.SplitHostPort(listen)
+	if err != nil || port == "0" {
+		return "", false
+	}
+	if host == "*" {
+		host = ""
+	}
+
+	return net.JoinHostPort(host, port), true
+}
+
+func forwardListen(forward string) string {
+	return strings.Fields(forward)[0]
+}
+
+func forwardTarget(forward string) string {
+	if fields := strings.Fields(forward); len(fields) > 1 {
+		return fields[1]
+	}
+
+	return ""
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestForwardAddress(t *testing.T) {
+	tests := []struct {
+		name    string
+		forward string
+		want    string
+		ok      bool
+	}{
+		{name: "-L port", forward: "8080 [localhost]:80", want: "localhost:8080", ok: true},
+		{name: "-L bind address", forward: "[127.0.0.1]:8081 [localhost]:80", want: "127.0.0.1:8081", ok: true},
+		{name: "-L IPv6 bind address", forward: "[::1]:8082 [::1]:80", want: "[::1]:8082", ok: true},
+		{name: "-L any address", forward: "[*]:8083 [h]:80", want: ":8083", ok: true},
+		{name: "-L unix socket", forward: "/tmp/s [h]:80"},
+		{name: "-L port chosen by ssh", forward: "0 [localhost]:80"},
+		{name: "-D port", forward: "1080", want: "localhost:1080", ok: true},
+		{name: "-D bind address", forward: "[127.0.0.1]:1081", want: "127.0.0.1:1081", ok: true},
+		{name: "-D IPv6 bind address", forward: "[::1]:1082", want: "[::1]:1082", ok: true},
+		{name: "-R port", forward: "9000 [localhost]:22", want: "localhost:9000", ok: true},
+		{name: "-R IPv6 bind address", forward: "[::1]:9001 [localhost]:22", want: "[::1]:9001", ok: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := forwardAddress(tt.forward)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("forwardAddress(%q) = %q, %v, want %q, %v", tt.forward, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestCheckForwards(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	_, busy, _ := net.SplitHostPort(l.Addr().String())
+
+	free, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, port, _ := net.SplitHostPort(free.Addr().String())
+	free.Close()
+
+	tests := []struct {
+		name    string
+		options map[string][]string
+		wantErr bool
+	}{
+		{name: "-L free port", options: map[string][]string{"localforward": {"[127.0.0.1]:" + port + " [localhost]:80"}}},
+		{name: "-L port in use", options: map[string][]string{"localforward": {"[127.0.0.1]:" + busy + " [localhost]:80"}}, wantErr: true},
+		{name: "-D port in use", options: map[string][]string{"dynamicforward": {"[127.0.0.1]:" + busy}}, wantErr: true},
+		{name: "-R port in use locally", options: map[string][]string{"remoteforward": {"[127.0.0.1]:" + busy + " [localhost]:22"}}},
+		{name: "unix socket", options: map[string][]string{"localforward": {"/tmp/s [h]:80"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := &target{options: tt.options}
+			if err := target.checkForwards(true); (err != nil) != tt.wantErr {
+				t.Errorf("checkForwards() = %v, want an error: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReminderCommand(t *testing.T) {
+	tests := []struct {
+		name    string
+		options map[string][]string
+		want    string
+	}{
+		{
+			name: "forwards",
+			options: map[string][]string{
+				"localforward":   {"[::1]:8080 [localhost]:80"},
+				"dynamicforward": {"1080"},
+				"remoteforward":  {"9000 [localhost]:22"},
+			},
+			want: `'printf' '%%s\n' 'forwarding [::1]:8080 to [localhost]:80' 'SOCKS proxy at 1080' 'forwarding 9000 on the instance to [localhost]:22' >&2`,
+		},
+		{
+			name: "LocalCommand of the ssh config",
+			options: map[string][]string{
+				"localforward":       {"8080 [localhost]:80"},
+				"permitlocalcommand": {"yes"},
+				"localcommand":       {"notify-send %h"},
+			},
+			want: `'printf' '%%s\n' 'forwarding 8080 to [localhost]:80' >&2; notify-send %h`,
+		},
+		{
+			name: "LocalCommand not permitted",
+			options: map[string][]string{
+				"localforward":       {"8080 [localhost]:80"},
+				"permitlocalcommand": {"no"},
+				"localcommand":       {"notify-send %h"},
+			},
+			want: `'printf' '%%s\n' 'forwarding 8080 to [localhost]:80' >&2`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := &target{options: tt.options}
+			if got := target.reminderCommand(); got != tt.want {
+				t.Errorf("reminderCommand() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckForwardsReminder(t *testing.T) {
+	target := &target{options: map[string][]string{"remoteforward": {"9000 [localhost]:22"}}, args: []string{"host"}}
+	if err := target.checkForwards(false); err != nil {
+		t.Fatal(err)
+	}
+
+	args := strings.Join(target.args, " ")
+	if !strings.Contains(args, "-o PermitLocalCommand=yes -o LocalCommand=") || !strings.HasSuffix(args, "-o ServerAliveInterval=30 host") {
+		t.Errorf("args = %s, want the reminder and the keep-alive before the destination", args)
+	}
+}
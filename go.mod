@@ -3,7 +3,17 @@ module github.com/bkielbasa/ec2-ssh
 go 1.16
 
 require (
-	github.com/aws/aws-sdk-go-v2/config v1.1.6
-	github.com/aws/aws-sdk-go-v2/service/ec2 v1.5.0
-	github.com/aws/aws-sdk-go-v2/service/ec2instanceconnect v1.2.1
+	github.com/aws/aws-sdk-go-v2 v1.16.16
+	github.com/aws/aws-sdk-go-v2/config v1.17.7
+	github.com/aws/aws-sdk-go-v2/credentials v1.12.20
+	github.com/aws/aws-sdk-go-v2/feature/ec2/imds v1.12.17
+	github.com/aws/aws-sdk-go-v2/service/ec2 v1.51.1
+	github.com/aws/aws-sdk-go-v2/service/ec2instanceconnect v1.13.0
+	github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2 v1.18.7
+	github.com/aws/aws-sdk-go-v2/service/resourcegroupstaggingapi v1.13.19
+	github.com/aws/aws-sdk-go-v2/service/route53 v1.21.2
+	github.com/aws/aws-sdk-go-v2/service/ssm v1.27.6
+	github.com/aws/aws-sdk-go-v2/service/sts v1.16.19
+	github.com/aws/smithy-go v1.13.3
+	gopkg.in/yaml.v2 v2.4.0
 )
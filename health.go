@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+const (
+	statusCheckPollInterval = 10 * time.Second
+	// the status checks of a freshly started instance usually pass within a few minutes
+	statusCheckTimeout = 10 * time.Minute
+)
+
+// statusChecksPassed reports whether both the system and the instance status checks are ok.
+func statusChecksPassed(status types.InstanceStatus) bool {
+	return summaryStatus(status.SystemStatus) == types.SummaryStatusOk &&
+		summaryStatus(status.InstanceStatus) == types.SummaryStatusOk
+}
+
+func summaryStatus(summary *types.InstanceStatusSummary) types.SummaryStatus {
+	if summary == nil {
+		return "unknown"
+	}
+
+	return summary.Status
+}
+
+// checkHealth returns the status of the instance once its status checks pass,
+// waiting for them with -wait.
+func checkHealth(ctx context.Context, client *ec2.Client, opts *toolOptions, inst types.Instance, status types.InstanceStatus) (types.InstanceStatus, error) {
+	if statusChecksPassed(status) {
+		return status, nil
+	}
+
+	if !opts.wait {
+		return status, fmt.Errorf("the status checks of %s haven't passed (system %s, instance %s), use -wait to wait for them",
+			*inst.InstanceId, summaryStatus(status.SystemStatus), summaryStatus(status.InstanceStatus))
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, statusCheckTimeout)
+	defer cancel()
+
+	for !statusChecksPassed(status) {
+		opts.logf("waiting for the status checks of %s (system %s, instance %s)",
+			*inst.InstanceId, summaryStatus(status.SystemStatus), summaryStatus(status.InstanceStatus))
+
+		select {
+		case <-ctx.Done():
+			return status, fmt.Errorf("the status checks of %s didn't pass in %s", *inst.InstanceId, statusCheckTimeout)
+		case <-time.After(statusCheckPollInterval):
+		}
+
+		var err error
+		status, err = instanceStatus(ctx, client, inst)
+		if err != nil {
+			return status, fmt.Errorf("cannot get the instance status: %w", err)
+		}
+	}
+
+	return status, nil
+}
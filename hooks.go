@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// runPreConnect runs the -pre-connect command, e.g. to bring a VPN up, before
+// anything is looked up in AWS. Its output goes to stderr so it doesn't mix
+// with what ec2-ssh prints, e.g. with -resolve-only.
+func runPreConnect(ctx context.Context, opts *toolOptions) error {
+	ctx, cancel := context.WithTimeout(ctx, opts.preTimeout)
+	defer cancel()
+
+	opts.logf("running the pre-connect command: %s", opts.preConnect)
+	cmd := exec.CommandContext(ctx, "sh", "-c", opts.preConnect)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("the pre-connect command didn't finish in %s", opts.preTimeout)
+	}
+	if err != nil {
+		return fmt.Errorf("the pre-connect command failed: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,427 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+)
+
+// keyTypes lists the key algorithms accepted by EC2 Instance Connect.
+var keyTypes = []string{"ed25519", "ecdsa", "rsa"}
+
+// generateEphemeralKey creates a throwaway key pair in a temporary directory
+// and returns the directory and the path to the private key.
+// The caller is responsible for removing the directory.
+func generateEphemeralKey(ctx context.Context, keyType string) (string, string, error) {
+	dir, err := os.MkdirTemp("", "ec2-ssh")
+	if err != nil {
+		return "", "", fmt.Errorf("cannot create a directory for the ephemeral key: %w", err)
+	}
+
+	path := filepath.Join(dir, "id_"+keyType)
+	if err := generateKey(ctx, path, keyType); err != nil {
+		_ = os.RemoveAll(dir)
+		return "", "", err
+	}
+
+	return dir, path, nil
+}
+
+func generateKey(ctx context.Context, path, keyType string) error {
+	args := []string{"-q", "-t", keyType, "-N", "", "-C", "ec2-ssh", "-f", path}
+	if keyType == "rsa" {
+		args = append(args, "-b", "4096")
+	}
+
+	out, err := exec.CommandContext(ctx, "ssh-keygen", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("cannot generate the ephemeral key: %w: %s", err, out)
+	}
+
+	return nil
+}
+
+// ephemeralKeyMaxAge is how long a cached ephemeral key is reused for, within the
+// validity of its first upload. Later sessions get a new one.
+const ephemeralKeyMaxAge = keyRefreshInterval
+
+// keyLockTimeout is how long to wait for another session to finish with the cached
+// key, a lock older than that is left over by a killed session.
+const keyLockTimeout = 30 * time.Second
+
+// cachedKey is an ephemeral key shared by the sessions to an instance and user,
+// see cachedEphemeralKey.
+type cachedKey struct {
+	// path is the private key
+	path string
+	// uploaded is the file marking when the key was uploaded
+	uploaded string
+	// fresh is set when the key was uploaded recently enough to skip the upload
+	fresh bool
+	// unlock lets other sessions use the key, called once the key is uploaded
+	unlock func()
+	// release deletes the key unless another session still uses it, called on exit
+	release func()
+}
+
+// cachedEphemeralKey returns the ephemeral key for the instance and user kept in
+// the user cache directory, so sessions opened in quick succession, e.g. in several
+// tmux panes, share the key and only the first one uploads it. A key is reused
+// for ephemeralKeyMaxAge, without uploading it again while its last upload is
+// younger than keyRefreshInterval, and deleted when the last session using it ends.
+func cachedEphemeralKey(ctx context.Context, keyType, instanceID, user string) (*cachedKey, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return nil, fmt.Errorf("cannot find the cache directory for the ephemeral key: %w", err)
+	}
+
+	keysDir := filepath.Join(cacheDir, "ec2-ssh", "keys")
+	hostDir := filepath.Join(keysDir, instanceID+"-"+user)
+	if err := os.MkdirAll(hostDir, 0o700); err != nil {
+		return nil, fmt.Errorf("cannot create a directory for the ephemeral key: %w", err)
+	}
+
+	unlock, err := lockFile(ctx, hostDir+".lock")
+	if err != nil {
+		return nil, err
+	}
+
+	removeExpiredKeys(keysDir, hostDir)
+
+	dir := recentKey(hostDir, keyType)
+	if dir == "" {
+		dir = filepath.Join(hostDir, fmt.Sprint(time.Now().UnixNano()))
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			unlock()
+			return nil, fmt.Errorf("cannot create a directory for the ephemeral key: %w", err)
+		}
+
+		if err := generateKey(ctx, filepath.Join(dir, "id_"+keyType), keyType); err != nil {
+			_ = os.RemoveAll(dir)
+			unlock()
+			return nil, err
+		}
+	}
+
+	// the session marks the key as used until it ends
+	marker, err := os.CreateTemp(dir, fmt.Sprintf("session-%d-", os.Getpid()))
+	if err != nil {
+		unlock()
+		return nil, fmt.Errorf("cannot mark the ephemeral key as used: %w", err)
+	}
+	_ = marker.Close()
+
+	key := &cachedKey{
+		path:     filepath.Join(dir, "id_"+keyType),
+		uploaded: filepath.Join(dir, "uploaded"),
+		unlock:   unlock,
+		release:  func() { releaseKey(hostDir, dir, marker.Name()) },
+	}
+	if fi, err := os.Stat(key.uploaded); err == nil && time.Since(fi.ModTime()) < keyRefreshInterval {
+		key.fresh = true
+	}
+
+	return key, nil
+}
+
+// recentKey returns the directory of the newest key of the type younger than
+// ephemeralKeyMaxAge, if any.
+func recentKey(hostDir, keyType string) string {
+	entries, err := os.ReadDir(hostDir)
+	if err != nil {
+		return ""
+	}
+
+	// the directories are named by their creation time
+	for i := len(entries) - 1; i >= 0; i-- {
+		dir := filepath.Join(hostDir, entries[i].Name())
+		if fi, err := os.Stat(filepath.Join(dir, "id_"+keyType)); err == nil && time.Since(fi.ModTime()) < ephemeralKeyMaxAge {
+			return dir
+		}
+	}
+
+	return ""
+}
+
+// releaseKey removes the session's mark from the key and deletes the key once
+// no other session uses it.
+func releaseKey(hostDir, dir, marker string) {
+	unlock, err := lockFile(context.Background(), hostDir+".lock")
+	if err != nil {
+		return
+	}
+	defer unlock()
+
+	_ = os.Remove(marker)
+	if !keyInUse(dir) {
+		_ = os.RemoveAll(dir)
+	}
+}
+
+// keyInUse reports whether a running session marked the key as used.
+func keyInUse(dir string) bool {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return false
+	}
+
+	for _, entry := range entries {
+		parts := strings.SplitN(entry.Name(), "-", 3)
+		if len(parts) != 3 || parts[0] != "session" {
+			continue
+		}
+
+		if pid, err := strconv.Atoi(parts[1]); err == nil && processRunning(pid) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func processRunning(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+
+	return p.Signal(syscall.Signal(0)) == nil
+}
+
+// removeExpiredKeys deletes the cached ephemeral keys older than ephemeralKeyMaxAge
+// no running session uses, e.g. left over by a killed one. The keys of other
+// instances a session holds the lock of are skipped, the held one is already locked.
+func removeExpiredKeys(keysDir, held string) {
+	hosts, err := os.ReadDir(keysDir)
+	if err != nil {
+		return
+	}
+
+	for _, host := range hosts {
+		hostDir := filepath.Join(keysDir, host.Name())
+		if !host.IsDir() {
+			continue
+		}
+		if _, err := os.Stat(hostDir + ".lock"); err == nil && hostDir != held {
+			continue
+		}
+
+		keys, err := os.ReadDir(hostDir)
+		if err != nil {
+			continue
+		}
+
+		for _, key := range keys {
+			dir := filepath.Join(hostDir, key.Name())
+			if fi, err := key.Info(); err == nil && time.Since(fi.ModTime()) > ephemeralKeyMaxAge && !keyInUse(dir) {
+				_ = os.RemoveAll(dir)
+			}
+		}
+
+		if hostDir != held {
+			// fails unless the directory is empty
+			_ = os.Remove(hostDir)
+		}
+	}
+}
+
+// lockFile creates the lock file, waiting while another process holds it. A lock
+// older than keyLockTimeout is taken over. It returns the function removing the lock.
+func lockFile(ctx context.Context, path string) (func(), error) {
+	deadline := time.Now().Add(keyLockTimeout)
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+		if err == nil {
+			_ = f.Close()
+			return func() { _ = os.Remove(path) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("cannot lock the ephemeral key: %w", err)
+		}
+
+		if fi, err := os.Stat(path); err == nil && time.Since(fi.ModTime()) > keyLockTimeout {
+			_ = os.Remove(path)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("cannot lock the ephemeral key, remove %s if no other ec2-ssh is running", path)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// loadPublicKey reads, fetches or generates the public key to upload to the instance.
+func (t *target) loadPublicKey(ctx context.Context, opts *toolOptions) error {
+	var err error
+	if opts.identityFromSSM != "" {
+		t.publicKey, err = publicKeyFromSSM(ctx, opts, t.instance.region, opts.identityFromSSM)
+		return err
+	}
+
+	if opts.pkcs11 != "" {
+		t.publicKey, err = listedPublicKey(ctx, opts, "the PKCS#11 library "+opts.pkcs11, "ssh-keygen", "-D", opts.pkcs11)
+		if err != nil {
+			return err
+		}
+
+		// the private key never leaves the token so ssh has to use it too
+		t.args = append([]string{"-o", "PKCS11Provider=" + opts.pkcs11}, t.args...)
+		return nil
+	}
+
+	if opts.agentKey {
+		t.publicKey, err = listedPublicKey(ctx, opts, "the ssh agent", "ssh-add", "-L")
+		return err
+	}
+
+	var pk string
+	if opts.ephemeral && !opts.serial {
+		t.cachedKey, err = cachedEphemeralKey(ctx, opts.keyType, t.instance.instanceID, t.instance.username)
+		if err != nil {
+			return err
+		}
+		pk = t.cachedKey.path
+
+		t.args = append([]string{"-i", pk, "-o", "IdentitiesOnly=yes"}, t.args...)
+	} else if opts.ephemeral {
+		t.tmpDir, pk, err = generateEphemeralKey(ctx, opts.keyType)
+		if err != nil {
+			return err
+		}
+
+		t.args = append([]string{"-i", pk, "-o", "IdentitiesOnly=yes"}, t.args...)
+	} else if opts.chooseKey && !opts.batch && isTerminal(os.Stdout) {
+		pk, err = chooseKey(opts, t.options["identityfile"])
+		if err != nil {
+			return err
+		}
+
+		t.args = append([]string{"-i", pk}, t.args...)
+	} else {
+		pk, err = existingKey(t.options["identityfile"])
+		if err != nil {
+			return err
+		}
+	}
+
+	t.identityFile = pk
+	t.publicKey, err = getPublicKey(pk)
+	if err != nil {
+		return withKind(ErrNoSSHKey, fmt.Errorf("cannot read the public key %s.pub: %w. If you want to provide a custom key location, use the `-i` parameter", pk, err))
+	}
+
+	return nil
+}
+
+// publicKeyFromSSM fetches the public key from an SSM parameter, decrypting
+// SecureString parameters. The matching private key has to be available to ssh,
+// e.g. through the agent or IdentityFile.
+func publicKeyFromSSM(ctx context.Context, opts *toolOptions, region, name string) (string, error) {
+	clients, err := clientsFor(ctx, opts, region)
+	if err != nil {
+		return "", fmt.Errorf("cannot get config for AWS: %w", err)
+	}
+
+	out, err := ssm.NewFromConfig(clients.cfg).GetParameter(ctx, &ssm.GetParameterInput{
+		Name:           &name,
+		WithDecryption: true,
+	})
+	if isAPIError(err, "ParameterNotFound") {
+		return "", fmt.Errorf("the SSM parameter %s doesn't exist in %s", name, region)
+	}
+	if err != nil {
+		return "", fmt.Errorf("cannot read the public key from the SSM parameter %s: %w", name, err)
+	}
+
+	key := strings.TrimSpace(*out.Parameter.Value)
+	if key == "" {
+		return "", fmt.Errorf("the SSM parameter %s is empty", name)
+	}
+
+	return key, nil
+}
+
+// listedPublicKey returns the public key listed by the command, e.g. ssh-add -L,
+// asking which one to upload when there's more than one.
+func listedPublicKey(ctx context.Context, opts *toolOptions, source, name string, args ...string) (string, error) {
+	out, err := exec.CommandContext(ctx, name, args...).Output()
+	// ssh-add exits with 1 when the agent has no keys
+	if err != nil && len(bytes.TrimSpace(out)) == 0 {
+		return "", withKind(ErrNoSSHKey, fmt.Errorf("cannot list the public keys of %s: %w", source, err))
+	}
+
+	var keys, items []string
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || (!strings.HasPrefix(fields[0], "ssh-") && !strings.HasPrefix(fields[0], "ecdsa-")) {
+			continue
+		}
+
+		keys = append(keys, strings.TrimSpace(line))
+		items = append(items, strings.Join(append([]string{fields[0]}, fields[2:]...), " "))
+	}
+
+	switch len(keys) {
+	case 0:
+		return "", withKind(ErrNoSSHKey, fmt.Errorf("%s has no public keys", source))
+	case 1:
+		return keys[0], nil
+	}
+
+	chosen, err := pick(opts, "Multiple public keys in "+source+":", items)
+	if err != nil {
+		return "", err
+	}
+
+	return keys[chosen], nil
+}
+
+// chooseKey asks the user which of the keys having a public key to upload
+// when there's more than one.
+func chooseKey(opts *toolOptions, paths []string) (string, error) {
+	var keys []string
+	for _, path := range paths {
+		path, err := expandHomeDirectoryTilde(path)
+		if err != nil {
+			return "", err
+		}
+
+		if _, err := os.Stat(path + ".pub"); err == nil {
+			keys = append(keys, path)
+		}
+	}
+
+	switch len(keys) {
+	case 0:
+		return "", withKind(ErrNoSSHKey, errors.New("cannot find any ssh key"))
+	case 1:
+		return keys[0], nil
+	}
+
+	chosen, err := pick(opts, "Multiple ssh keys found:", keys)
+	if err != nil {
+		return "", err
+	}
+
+	return keys[chosen], nil
+}
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCachedEphemeralKey(t *testing.T) {
+	if _, err := exec.LookPath("ssh-keygen"); err != nil {
+		t.Skip("ssh-keygen is not installed")
+	}
+
+	cacheDir := t.TempDir()
+	setenv(t, "XDG_CACHE_HOME", cacheDir)
+	setenv(t, "HOME", cacheDir)
+	ctx := context.Background()
+
+	first, err := cachedEphemeralKey(ctx, "ed25519", "i-0123456789abcdef0", "ec2-user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first.unlock()
+	if first.fresh {
+		t.Error("a new key is fresh")
+	}
+	if err := os.WriteFile(first.uploaded, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := cachedEphemeralKey(ctx, "ed25519", "i-0123456789abcdef0", "ec2-user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second.unlock()
+	if second.path != first.path || !second.fresh {
+		t.Errorf("the second session got %s, fresh = %t, want the fresh %s", second.path, second.fresh, first.path)
+	}
+
+	first.release()
+	if _, err := os.Stat(first.path); err != nil {
+		t.Errorf("the key shared with the second session was deleted: %s", err)
+	}
+
+	second.release()
+	if _, err := os.Stat(filepath.Dir(first.path)); !os.IsNotExist(err) {
+		t.Errorf("the key wasn't deleted after the last session, err = %v", err)
+	}
+
+	// a key older than the reuse window is replaced
+	third, err := cachedEphemeralKey(ctx, "ed25519", "i-0123456789abcdef0", "ec2-user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	third.unlock()
+	defer third.release()
+
+	old := time.Now().Add(-2 * ephemeralKeyMaxAge)
+	if err := os.Chtimes(third.path, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	fourth, err := cachedEphemeralKey(ctx, "ed25519", "i-0123456789abcdef0", "ec2-user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fourth.unlock()
+	defer fourth.release()
+
+	if fourth.path == third.path {
+		t.Errorf("the key older than %s was reused", ephemeralKeyMaxAge)
+	}
+	if _, err := os.Stat(third.path); err != nil {
+		t.Errorf("the expired key still used by a session was deleted: %s", err)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const keyscanTimeout = 5 * time.Second
+
+// knownHostsPath returns ec2-ssh's own known_hosts file. With -instance-known-hosts
+// every instance has its own file, so a new instance reusing the IP of an old one
+// doesn't conflict with its host keys.
+func (t *target) knownHostsPath(opts *toolOptions) (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+
+	if opts.instKnownHosts && t.found {
+		return filepath.Join(dir, "ec2-ssh", "known_hosts.d", t.instance.instanceID), nil
+	}
+
+	return filepath.Join(dir, "ec2-ssh", "known_hosts"), nil
+}
+
+// useInstanceKnownHosts makes ssh keep the host keys of the instance in its own
+// known_hosts file.
+func (t *target) useInstanceKnownHosts(opts *toolOptions) error {
+	path, err := t.knownHostsPath(opts)
+	if err != nil {
+		return fmt.Errorf("cannot find the known_hosts file: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("cannot create the known_hosts file: %w", err)
+	}
+
+	opts.logf("using the known_hosts file %s", path)
+	t.args = append([]string{"-o", "UserKnownHostsFile=" + path}, t.args...)
+
+	return nil
+}
+
+// keyscan adds the host keys of the instance to ec2-ssh's own known_hosts file
+// and makes ssh use it, so ssh doesn't ask to confirm them.
+func (t *target) keyscan(ctx context.Context, opts *toolOptions) error {
+	if t.proxy != "" {
+		opts.logf("ssh connects through %s, skipping ssh-keyscan", t.proxy)
+		return nil
+	}
+
+	address := t.instance.host
+	switch {
+	case opts.connectHost != "":
+		address = opts.connectHost
+	case t.instance.connectIP:
+		address = t.instance.ipAddress
+	}
+
+	path, err := t.knownHostsPath(opts)
+	if err != nil {
+		return fmt.Errorf("cannot find the known_hosts file: %w", err)
+	}
+
+	timeout := fmt.Sprint(int(keyscanTimeout.Seconds()))
+	out, err := exec.CommandContext(ctx, "ssh-keyscan", "-T", timeout, "-p", t.port, address).Output()
+	if err != nil {
+		return fmt.Errorf("ssh-keyscan failed: %w", err)
+	}
+
+	// ssh-keyscan succeeds even if it cannot connect
+	if strings.TrimSpace(string(out)) == "" {
+		return fmt.Errorf("ssh-keyscan got no host keys from %s port %s in %s, is the port blocked?", address, t.port, keyscanTimeout)
+	}
+
+	// ssh looks the keys up by the alias instead of the address
+	if alias := t.hostKeyAlias(); alias != "" {
+		out = withHostName(out, alias)
+		address = alias
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("cannot create the known_hosts file: %w", err)
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("cannot open the known_hosts file: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(out); err != nil {
+		return fmt.Errorf("cannot write the known_hosts file: %w", err)
+	}
+
+	opts.logf("added the host keys of %s to %s", address, path)
+	t.args = append([]string{"-o", "UserKnownHostsFile=" + path}, t.args...)
+
+	return nil
+}
+
+// hostKeyAlias returns the HostKeyAlias ssh uses, the first one given with -o,
+// including the derived options, or the one from the ssh config.
+func (t *target) hostKeyAlias() string {
+	for i := 0; i < len(t.args) && strings.HasPrefix(t.args[i], "-"); i++ {
+		option := strings.TrimPrefix(t.args[i], "-o")
+		if t.args[i] == "-o" && i+1 < len(t.args) {
+			option = t.args[i+1]
+		}
+
+		// ssh takes both Name=value and Name value
+		if n := strings.IndexAny(option, "= "); n > 0 && strings.EqualFold(option[:n], "HostKeyAlias") {
+			return strings.TrimSpace(option[n+1:])
+		}
+
+		if sshFlagTakesValue(t.args[i]) {
+			i++
+		}
+	}
+
+	if alias := t.options["hostkeyalias"]; len(alias) > 0 {
+		return alias[0]
+	}
+
+	return ""
+}
+
+// withHostName replaces the host of the ssh-keyscan output lines with the name.
+func withHostName(keys []byte, name string) []byte {
+	var b strings.Builder
+	for _, line := range strings.SplitAfter(string(keys), "\n") {
+		fields := strings.SplitN(line, " ", 2)
+		if len(fields) == 2 && !strings.HasPrefix(line, "#") {
+			line = name + " " + fields[1]
+		}
+		b.WriteString(line)
+	}
+
+	return []byte(b.String())
+}
@@ -0,0 +1,38 @@
+package main
+
+import "testing"
+
+func TestHostKeyAlias(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		options map[string][]string
+		want    string
+	}{
+		{name: "none", args: []string{"-v", "10.0.0.5"}, want: ""},
+		{name: "derived", args: []string{"-o", "HostKeyAlias=i-0123456789abcdef0", "-o", "HostKeyAlias=other", "10.0.0.5"}, want: "i-0123456789abcdef0"},
+		{name: "joined -o", args: []string{"-oHostKeyAlias=web", "10.0.0.5"}, want: "web"},
+		{name: "case and space", args: []string{"-o", "hostkeyalias web", "10.0.0.5"}, want: "web"},
+		{name: "after the destination", args: []string{"10.0.0.5", "-o", "HostKeyAlias=web"}, want: ""},
+		{name: "ssh config", args: []string{"10.0.0.5"}, options: map[string][]string{"hostkeyalias": {"web"}}, want: "web"},
+		{name: "-o beats the ssh config", args: []string{"-o", "HostKeyAlias=i-0123456789abcdef0", "10.0.0.5"}, options: map[string][]string{"hostkeyalias": {"web"}}, want: "i-0123456789abcdef0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := &target{args: tt.args, options: tt.options}
+			if got := target.hostKeyAlias(); got != tt.want {
+				t.Errorf("hostKeyAlias() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithHostName(t *testing.T) {
+	keys := "10.0.0.5 ssh-ed25519 AAAAC3Nza\n[10.0.0.5]:2222 ecdsa-sha2-nistp256 AAAAE2Vj\n"
+	want := "i-0123456789abcdef0 ssh-ed25519 AAAAC3Nza\ni-0123456789abcdef0 ecdsa-sha2-nistp256 AAAAE2Vj\n"
+
+	if got := string(withHostName([]byte(keys), "i-0123456789abcdef0")); got != want {
+		t.Errorf("withHostName() = %q, want %q", got, want)
+	}
+}
@@ -10,8 +10,20 @@ func main() {
 	args := os.Args[1:]
 	ctx := context.Background()
 
-	if err := ssh(ctx, args); err != nil {
-		fmt.Println(err)
+	var err error
+	switch {
+	case len(args) > 0 && args[0] == "doctor":
+		err = doctor(ctx, os.Stdout, args[1:])
+	case len(args) > 0 && args[0] == "snapshot":
+		err = takeSnapshot(ctx, os.Stdout, args[1:])
+	case len(args) > 0 && args[0] == "permissions":
+		err = permissions(os.Stdout, args[1:])
+	default:
+		err = ssh(ctx, args)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
 }
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// menu resolves every destination and connects to the one the user chooses.
+// Destinations that cannot be resolved are shown in the menu with the reason.
+func menu(ctx context.Context, opts *toolOptions, args []string) error {
+	sshArgs, destinations := splitDestinations(args)
+	if len(destinations) == 0 {
+		return errors.New("no destinations given")
+	}
+
+	targets := make([]*target, len(destinations))
+	errs := make([]error, len(destinations))
+	items := make([]string, len(destinations))
+	for i, dest := range destinations {
+		targets[i], errs[i] = resolve(ctx, opts, append(sshArgs[:len(sshArgs):len(sshArgs)], dest))
+
+		switch {
+		case errs[i] != nil:
+			items[i] = fmt.Sprintf("%s (cannot resolve: %s)", dest, errs[i])
+		case !targets[i].found:
+			items[i] = fmt.Sprintf("%s (not an EC2 instance)", dest)
+		default:
+			items[i] = fmt.Sprintf("%s (%s %s in %s)", dest, targets[i].instance.instanceID, targets[i].instance.ipAddress, targets[i].instance.region)
+		}
+	}
+
+	chosen, err := pick(opts, "Connect to:", items)
+	if err != nil {
+		return err
+	}
+
+	if errs[chosen] != nil {
+		return errs[chosen]
+	}
+
+	t := targets[chosen]
+	defer t.close()
+
+	if err := t.authorize(ctx, opts); err != nil {
+		return err
+	}
+
+	return connect(ctx, opts, t)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// findByNameIgnoringCase looks for the instances whose Name tag matches the name
+// case-insensitively, as the EC2 filters are case-sensitive. Names that are close
+// but don't match are remembered in the instance info to be suggested.
+// The owners of the matching instances are added to owners.
+func findByNameIgnoringCase(ctx context.Context, client *ec2.Client, opts *toolOptions, info *instanceInfo, owners map[string]string) ([]types.Instance, error) {
+	filters := []types.Filter{
+		{
+			Name:   strp("tag-key"),
+			Values: []string{"Name"},
+		},
+		{
+			Name:   strp("instance-state-name"),
+			Values: opts.instanceStates(),
+		},
+	}
+
+	var matches []types.Instance
+	pages := ec2.NewDescribeInstancesPaginator(client, &ec2.DescribeInstancesInput{
+		Filters: append(filters, opts.filters()...),
+	})
+	for pages.HasMorePages() {
+		resp, err := pages.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, r := range resp.Reservations {
+			for _, inst := range r.Instances {
+				name := tagValue(inst, "Name")
+				switch {
+				case info.namePrefix && strings.HasPrefix(strings.ToLower(name), strings.ToLower(info.name)),
+					strings.EqualFold(name, info.name):
+					matches = append(matches, inst)
+					owners[*inst.InstanceId] = aws.ToString(r.OwnerId)
+				case similarNames(name, info.name) && !contains(info.nearNames, name):
+					info.nearNames = append(info.nearNames, name)
+				}
+			}
+		}
+	}
+
+	return matches, nil
+}
+
+// noteCaseMatch tells which instance a case-insensitive match led to,
+// as its name isn't the one given.
+func noteCaseMatch(info *instanceInfo, inst types.Instance) {
+	fmt.Fprintf(os.Stderr, "no instance is named %s with that case, using %s named %s\n", info.name, *inst.InstanceId, tagValue(inst, "Name"))
+}
+
+// similarNames reports whether one name contains the other or they differ by a typo or two.
+func similarNames(a, b string) bool {
+	a, b = strings.ToLower(a), strings.ToLower(b)
+	if strings.Contains(a, b) || strings.Contains(b, a) {
+		return true
+	}
+
+	return editDistance(a, b) <= 2
+}
+
+// editDistance returns the Levenshtein distance between the strings.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+
+	return prev[len(b)]
+}
+
+func minInt(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+
+	return m
+}
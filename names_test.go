@@ -0,0 +1,44 @@
+package main
+
+import "testing"
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{a: "", b: "", want: 0},
+		{a: "web", b: "", want: 3},
+		{a: "", b: "web", want: 3},
+		{a: "web", b: "web", want: 0},
+		{a: "web", b: "wbe", want: 2},
+		{a: "web-1", b: "web-2", want: 1},
+		{a: "kitten", b: "sitting", want: 3},
+		{a: "api", b: "apis", want: 1},
+	}
+
+	for _, tt := range tests {
+		if got := editDistance(tt.a, tt.b); got != tt.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestSimilarNames(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{a: "Web-Prod", b: "web-prod", want: true},
+		{a: "web", b: "web-prod", want: true},
+		{a: "web-prd", b: "web-prod", want: true},
+		{a: "wbe-porde", b: "web-prod", want: false},
+		{a: "db", b: "web-prod", want: false},
+	}
+
+	for _, tt := range tests {
+		if got := similarNames(tt.a, tt.b); got != tt.want {
+			t.Errorf("similarNames(%q, %q) = %t, want %t", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
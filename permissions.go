@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// apiPermissions lists the IAM actions of the AWS API calls ec2-ssh makes
+// and whether the given options need them. TestAPIPermissions checks every
+// call in the code has an entry.
+var apiPermissions = []struct {
+	action string
+	needed func(opts *toolOptions) bool
+}{
+	{"ec2:DescribeInstances", func(opts *toolOptions) bool { return !opts.offline }},
+	{"ec2:DescribeRegions", func(opts *toolOptions) bool { return !opts.offline }},
+	{"ec2:DescribeInstanceStatus", func(opts *toolOptions) bool { return !opts.skipStatus }},
+	{"ec2-instance-connect:SendSSHPublicKey", func(opts *toolOptions) bool { return !opts.readOnly && !opts.serial }},
+	{"ec2-instance-connect:SendSerialConsoleSSHPublicKey", func(opts *toolOptions) bool { return opts.serial }},
+	{"ec2:DescribeImages", func(opts *toolOptions) bool { return opts.autoUser || opts.listUsers }},
+	{"ec2:StartInstances", func(opts *toolOptions) bool { return opts.start }},
+	{"ec2:DescribeNetworkInterfaces", func(opts *toolOptions) bool { return opts.eni != "" }},
+	{"elasticloadbalancing:DescribeTargetHealth", func(opts *toolOptions) bool { return opts.targetGroup != "" }},
+	{"sts:AssumeRole", func(opts *toolOptions) bool { return len(opts.fileConfig.AccountRoles) > 0 }},
+	{"route53:ListResourceRecordSets", func(opts *toolOptions) bool { return opts.route53Zone != "" }},
+	{"tag:GetResources", func(opts *toolOptions) bool { return opts.taggingAPI }},
+	{"ssm:GetParameter", func(opts *toolOptions) bool { return opts.identityFromSSM != "" }},
+	// a SecureString parameter is decrypted with its KMS key
+	{"kms:Decrypt", func(opts *toolOptions) bool { return opts.identityFromSSM != "" }},
+}
+
+type policyDocument struct {
+	Version   string            `json:"Version"`
+	Statement []policyStatement `json:"Statement"`
+}
+
+type policyStatement struct {
+	Effect   string   `json:"Effect"`
+	Action   []string `json:"Action"`
+	Resource string   `json:"Resource"`
+}
+
+// permissions prints the minimal IAM policy needed to use ec2-ssh with the given options.
+func permissions(w io.Writer, args []string) error {
+	opts, _, err := parseArgs(args)
+	if err != nil {
+		return err
+	}
+
+	var actions []string
+	for _, p := range apiPermissions {
+		if p.needed(opts) {
+			actions = append(actions, p.action)
+		}
+	}
+
+	policy := policyDocument{
+		Version: "2012-10-17",
+		Statement: []policyStatement{
+			{
+				Effect:   "Allow",
+				Action:   actions,
+				Resource: "*",
+			},
+		},
+	}
+
+	out, err := json.MarshalIndent(policy, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(w, string(out))
+	return nil
+}
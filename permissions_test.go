@@ -0,0 +1,154 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// iamPrefixes maps the SDK service packages to the prefixes of their IAM actions.
+var iamPrefixes = map[string]string{
+	"ec2":                      "ec2",
+	"ec2instanceconnect":       "ec2-instance-connect",
+	"elasticloadbalancingv2":   "elasticloadbalancing",
+	"resourcegroupstaggingapi": "tag",
+	"route53":                  "route53",
+	"ssm":                      "ssm",
+	"sts":                      "sts",
+}
+
+// implicitActions are called by AWS for ec2-ssh, not by its code.
+var implicitActions = map[string]bool{
+	// by SSM for a SecureString parameter
+	"kms:Decrypt": true,
+	// by the stscreds provider of account_roles
+	"sts:AssumeRole": true,
+}
+
+// unauthorizedActions need no permission.
+var unauthorizedActions = map[string]bool{
+	"sts:GetCallerIdentity": true,
+}
+
+// apiCalls returns the IAM actions of the AWS API calls in the code, found by
+// the SDK input structs passed to them.
+func apiCalls(t *testing.T) map[string][]string {
+	t.Helper()
+
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	calls := map[string][]string{}
+	fset := token.NewFileSet()
+	for _, name := range files {
+		if strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+
+		f, err := parser.ParseFile(fset, name, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		services := map[string]string{}
+		for _, imp := range f.Imports {
+			path, _ := strconv.Unquote(imp.Path.Value)
+			if !strings.HasPrefix(path, "github.com/aws/aws-sdk-go-v2/service/") {
+				continue
+			}
+
+			pkg := filepath.Base(path)
+			local := pkg
+			if imp.Name != nil {
+				local = imp.Name.Name
+			}
+			services[local] = pkg
+		}
+
+		ast.Inspect(f, func(n ast.Node) bool {
+			lit, ok := n.(*ast.CompositeLit)
+			if !ok {
+				return true
+			}
+			sel, ok := lit.Type.(*ast.SelectorExpr)
+			if !ok || !strings.HasSuffix(sel.Sel.Name, "Input") {
+				return true
+			}
+			ident, ok := sel.X.(*ast.Ident)
+			if !ok || services[ident.Name] == "" {
+				return true
+			}
+
+			prefix, ok := iamPrefixes[services[ident.Name]]
+			if !ok {
+				t.Errorf("%s: no IAM prefix for the service %s", fset.Position(lit.Pos()), services[ident.Name])
+				return true
+			}
+
+			action := prefix + ":" + strings.TrimSuffix(sel.Sel.Name, "Input")
+			calls[action] = append(calls[action], fset.Position(lit.Pos()).String())
+			return true
+		})
+	}
+
+	return calls
+}
+
+func TestAPIPermissions(t *testing.T) {
+	calls := apiCalls(t)
+	if len(calls) == 0 {
+		t.Fatal("no AWS API calls found")
+	}
+
+	listed := map[string]bool{}
+	for _, p := range apiPermissions {
+		listed[p.action] = true
+	}
+
+	var actions []string
+	for action := range calls {
+		actions = append(actions, action)
+	}
+	sort.Strings(actions)
+
+	for _, action := range actions {
+		if !listed[action] && !unauthorizedActions[action] {
+			t.Errorf("%s, called at %s, is missing from apiPermissions", action, strings.Join(calls[action], ", "))
+		}
+	}
+
+	for action := range listed {
+		if calls[action] == nil && !implicitActions[action] {
+			t.Errorf("%s is in apiPermissions but never called", action)
+		}
+	}
+}
+
+func TestPermissionsSecureString(t *testing.T) {
+	setenv(t, "EC2SSH_CONFIG", filepath.Join(t.TempDir(), "missing.yaml"))
+
+	opts, _, err := parseArgs([]string{"-identity-from-ssm", "/keys/alice", "host"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var needed []string
+	for _, p := range apiPermissions {
+		if p.needed(opts) {
+			needed = append(needed, p.action)
+		}
+	}
+
+	for _, action := range []string{"ssm:GetParameter", "kms:Decrypt"} {
+		if !contains(needed, action) {
+			t.Errorf("the permissions of -identity-from-ssm = %v, missing %s", needed, action)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// pick asks the user to choose one of the items and returns its index.
+// It fails without asking in batch mode.
+func pick(opts *toolOptions, prompt string, items []string) (int, error) {
+	if opts.batch {
+		return 0, fmt.Errorf("cannot ask to choose in batch mode: %s %s", prompt, strings.Join(items, ", "))
+	}
+
+	fmt.Fprintln(os.Stderr, prompt)
+	for i, item := range items {
+		fmt.Fprintf(os.Stderr, "  %d) %s\n", i+1, item)
+	}
+	fmt.Fprintf(os.Stderr, "choose [1-%d]: ", len(items))
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return 0, fmt.Errorf("cannot read the choice: %w", err)
+	}
+
+	n, err := strconv.Atoi(strings.TrimSpace(line))
+	if err != nil || n < 1 || n > len(items) {
+		return 0, fmt.Errorf("invalid choice %q", strings.TrimSpace(line))
+	}
+
+	return n - 1, nil
+}
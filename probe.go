@@ -0,0 +1,27 @@
+package main
+
+import (
+	"context"
+	"os/exec"
+)
+
+// keyAuthorized reports whether ssh can already log in to the instance without
+// uploading the key, e.g. because the key is in authorized_keys.
+func (t *target) keyAuthorized(ctx context.Context, opts *toolOptions) bool {
+	i := destinationIndex(t.args)
+	if i < 0 {
+		return false
+	}
+
+	// never prompt for anything and give up quickly, the upload is the fallback
+	args := []string{"-o", "BatchMode=yes", "-o", "ConnectTimeout=3", "-o", "User=" + t.instance.username}
+	args = append(append(args, t.args[:i+1]...), "true")
+
+	out, err := exec.CommandContext(ctx, "ssh", args...).CombinedOutput()
+	if err != nil {
+		opts.logf("the key isn't authorized on %s yet: %s", t.instance, out)
+		return false
+	}
+
+	return true
+}
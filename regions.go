@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/ec2/imds"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+)
+
+var regions = []string{"us-west-1", "us-west-2"}
+
+// resolveRegions returns the ordered list of regions to scan.
+// The first explicit list of regions wins:
+//  1. the -region flag
+//  2. the EC2SSH_REGIONS environment variable
+//
+// Otherwise the regions of the AWS profile in the config file, the regions from
+// the config file or the default regions are scanned, preceded by the first
+// region found in:
+//  3. the AWS_REGION or AWS_DEFAULT_REGION environment variables
+//  4. the region of the AWS profile in the shared config, before 3. with -prefer-profile-region
+//  5. the instance metadata when running on EC2
+//
+// 4. without -prefer-profile-region and 5. are the SDK's defaults, so they
+// only precede the default regions, not the lists of the config file.
+func resolveRegions(ctx context.Context, opts *toolOptions) []string {
+	var env listValue
+	_ = env.Set(os.Getenv("EC2SSH_REGIONS"))
+
+	if list := firstList(opts.regions, env); len(list) > 0 {
+		return list
+	}
+
+	profileRegion := func() string { return sharedConfigRegion(ctx, opts) }
+	sources := []func() string{envRegion}
+	if opts.profileRegion {
+		sources = append([]func() string{profileRegion}, sources...)
+	}
+
+	scan := regions
+	if list := firstList(opts.fileConfig.Profiles[opts.profile].Regions, opts.fileConfig.Regions); len(list) > 0 {
+		scan = list
+	} else {
+		if !opts.profileRegion {
+			sources = append(sources, profileRegion)
+		}
+		sources = append(sources, func() string { return imdsRegion(ctx) })
+	}
+
+	preferred := ""
+	for _, source := range sources {
+		if preferred = source(); preferred != "" {
+			break
+		}
+	}
+
+	return prependRegion(preferred, scan)
+}
+
+func firstList(lists ...[]string) []string {
+	for _, list := range lists {
+		if len(list) > 0 {
+			return list
+		}
+	}
+
+	return nil
+}
+
+func envRegion() string {
+	if region := os.Getenv("AWS_REGION"); region != "" {
+		return region
+	}
+
+	return os.Getenv("AWS_DEFAULT_REGION")
+}
+
+// sharedConfigRegion returns the region of the AWS profile in the shared config,
+// read directly so the environment variables don't override it.
+func sharedConfigRegion(ctx context.Context, opts *toolOptions) string {
+	profile := opts.profile
+	if profile == "" {
+		profile = config.DefaultSharedConfigProfile
+	}
+
+	cfg, err := config.LoadSharedConfigProfile(ctx, profile)
+	if err != nil {
+		return ""
+	}
+
+	return cfg.Region
+}
+
+func imdsRegion(ctx context.Context) string {
+	// don't wait long when not running on EC2
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+
+	out, err := imds.New(imds.Options{}).GetRegion(ctx, &imds.GetRegionInput{})
+	if err != nil {
+		return ""
+	}
+
+	return out.Region
+}
+
+// enabledRegions drops the regions the account isn't opted in to from the list,
+// as the API calls fail there. The list is asked for in its first region.
+func enabledRegions(ctx context.Context, opts *toolOptions, scan []string) ([]string, error) {
+	clients, err := clientsFor(ctx, opts, scan[0])
+	if err != nil {
+		return nil, err
+	}
+
+	// without AllRegions only the enabled regions are listed
+	out, err := clients.ec2.DescribeRegions(ctx, &ec2.DescribeRegionsInput{})
+	if err != nil {
+		return nil, fmt.Errorf("cannot list the enabled regions: %w", err)
+	}
+
+	enabled := map[string]bool{}
+	for _, r := range out.Regions {
+		enabled[*r.RegionName] = true
+	}
+
+	var regions []string
+	for _, region := range scan {
+		if enabled[region] {
+			regions = append(regions, region)
+		} else {
+			opts.logf("%s: opt-in required, skipped", region)
+		}
+	}
+
+	return regions, nil
+}
+
+// prependRegion moves the region to the front of the list.
+func prependRegion(region string, regions []string) []string {
+	if region == "" {
+		return regions
+	}
+
+	list := []string{region}
+	for _, r := range regions {
+		if r != region {
+			list = append(list, r)
+		}
+	}
+
+	return list
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+)
+
+func TestResolveRegions(t *testing.T) {
+	sharedConfig := filepath.Join(t.TempDir(), "config")
+	content := "[default]\nregion = eu-west-1\n\n[profile dev]\nregion = eu-central-1\n"
+	if err := os.WriteFile(sharedConfig, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	defaultFiles := config.DefaultSharedConfigFiles
+	config.DefaultSharedConfigFiles = []string{sharedConfig}
+	t.Cleanup(func() { config.DefaultSharedConfigFiles = defaultFiles })
+
+	withRegions := &fileConfig{
+		Regions: []string{"ap-south-1"},
+		Profiles: map[string]profileConfig{
+			"prod": {Regions: []string{"sa-east-1", "ca-central-1"}},
+		},
+	}
+
+	tests := []struct {
+		name          string
+		regions       listValue
+		profile       string
+		profileRegion bool
+		file          *fileConfig
+		env           map[string]string
+		want          []string
+	}{
+		{
+			name:    "the -region flag wins",
+			regions: listValue{"eu-north-1"},
+			profile: "prod",
+			file:    withRegions,
+			env:     map[string]string{"EC2SSH_REGIONS": "us-east-1", "AWS_REGION": "us-east-2"},
+			want:    []string{"eu-north-1"},
+		},
+		{
+			name:    "EC2SSH_REGIONS before the config file",
+			profile: "prod",
+			file:    withRegions,
+			env:     map[string]string{"EC2SSH_REGIONS": "us-east-1,us-east-2"},
+			want:    []string{"us-east-1", "us-east-2"},
+		},
+		{
+			name:    "the regions of the profile",
+			profile: "prod",
+			file:    withRegions,
+			want:    []string{"sa-east-1", "ca-central-1"},
+		},
+		{
+			name:    "the regions of the config file before the region of the profile",
+			profile: "dev",
+			file:    withRegions,
+			want:    []string{"ap-south-1"},
+		},
+		{
+			name:    "AWS_REGION before the regions of the profile",
+			profile: "prod",
+			file:    withRegions,
+			env:     map[string]string{"AWS_REGION": "us-east-1"},
+			want:    []string{"us-east-1", "sa-east-1", "ca-central-1"},
+		},
+		{
+			name:    "AWS_DEFAULT_REGION before the regions of the config file",
+			profile: "dev",
+			file:    withRegions,
+			env:     map[string]string{"AWS_DEFAULT_REGION": "us-east-2"},
+			want:    []string{"us-east-2", "ap-south-1"},
+		},
+		{
+			name:          "-prefer-profile-region before the regions of the config file",
+			profile:       "dev",
+			profileRegion: true,
+			file:          withRegions,
+			env:           map[string]string{"AWS_REGION": "us-east-1"},
+			want:          []string{"eu-central-1", "ap-south-1"},
+		},
+		{
+			name: "AWS_REGION precedes the default regions",
+			env:  map[string]string{"AWS_REGION": "us-east-1", "AWS_DEFAULT_REGION": "us-east-2"},
+			want: []string{"us-east-1", "us-west-1", "us-west-2"},
+		},
+		{
+			name: "AWS_DEFAULT_REGION without AWS_REGION",
+			env:  map[string]string{"AWS_DEFAULT_REGION": "us-west-2"},
+			want: []string{"us-west-2", "us-west-1"},
+		},
+		{
+			name:    "AWS_REGION before the region of the profile",
+			profile: "dev",
+			env:     map[string]string{"AWS_REGION": "us-east-1"},
+			want:    []string{"us-east-1", "us-west-1", "us-west-2"},
+		},
+		{
+			name:          "-prefer-profile-region",
+			profile:       "dev",
+			profileRegion: true,
+			env:           map[string]string{"AWS_REGION": "us-east-1"},
+			want:          []string{"eu-central-1", "us-west-1", "us-west-2"},
+		},
+		{
+			name:    "the region of the profile without AWS_REGION",
+			profile: "dev",
+			want:    []string{"eu-central-1", "us-west-1", "us-west-2"},
+		},
+		{
+			name: "the region of the default profile",
+			want: []string{"eu-west-1", "us-west-1", "us-west-2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, key := range []string{"EC2SSH_REGIONS", "AWS_REGION", "AWS_DEFAULT_REGION"} {
+				setenv(t, key, tt.env[key])
+			}
+
+			opts := &toolOptions{
+				regions:       tt.regions,
+				profile:       tt.profile,
+				profileRegion: tt.profileRegion,
+				fileConfig:    tt.file,
+			}
+			if opts.fileConfig == nil {
+				opts.fileConfig = &fileConfig{}
+			}
+
+			got := resolveRegions(context.Background(), opts)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("resolveRegions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	r53types "github.com/aws/aws-sdk-go-v2/service/route53/types"
+)
+
+// route53Region is where the clients for the global Route 53 API are created.
+const route53Region = "us-east-1"
+
+// maxRecordHops limits following CNAMEs and aliases within the hosted zone.
+const maxRecordHops = 5
+
+// resolveRoute53 returns the IP the record of the host points to in the -route53-zone
+// hosted zone, following the CNAMEs and aliases to records of the same zone. It
+// works for private hosted zones without the VPC's DNS.
+func resolveRoute53(ctx context.Context, opts *toolOptions, host string) (string, error) {
+	clients, err := clientsFor(ctx, opts, route53Region)
+	if err != nil {
+		return "", fmt.Errorf("cannot get config for AWS: %w", err)
+	}
+	client := route53.NewFromConfig(clients.cfg)
+
+	name := host
+	for hop := 0; hop < maxRecordHops; hop++ {
+		record, err := findRecord(ctx, client, opts.route53Zone, name)
+		if err != nil {
+			return "", err
+		}
+
+		switch {
+		case record.AliasTarget != nil:
+			target := strings.TrimSuffix(aws.ToString(record.AliasTarget.DNSName), ".")
+			if aws.ToString(record.AliasTarget.HostedZoneId) != opts.route53Zone {
+				return "", fmt.Errorf("%s is an alias to %s outside of the hosted zone, e.g. a load balancer, which is not supported; use -target-group for load balancers", name, target)
+			}
+			name = target
+		case record.Type == r53types.RRTypeCname:
+			name = strings.TrimSuffix(aws.ToString(record.ResourceRecords[0].Value), ".")
+		default:
+			ip := aws.ToString(record.ResourceRecords[0].Value)
+			if net.ParseIP(ip) == nil {
+				return "", fmt.Errorf("the record %s has no IP but %q", name, ip)
+			}
+			opts.logf("%s points to %s in the hosted zone %s", host, ip, opts.route53Zone)
+			return ip, nil
+		}
+	}
+
+	return "", fmt.Errorf("%s leads through more than %d records in the hosted zone %s", host, maxRecordHops, opts.route53Zone)
+}
+
+// findRecord returns the A or CNAME record, or an alias, of the name in the hosted zone.
+func findRecord(ctx context.Context, client *route53.Client, zone, name string) (r53types.ResourceRecordSet, error) {
+	out, err := client.ListResourceRecordSets(ctx, &route53.ListResourceRecordSetsInput{
+		HostedZoneId:    &zone,
+		StartRecordName: &name,
+		MaxItems:        aws.Int32(10),
+	})
+	if err != nil {
+		return r53types.ResourceRecordSet{}, fmt.Errorf("cannot list the records of the hosted zone %s: %w", zone, err)
+	}
+
+	// the records are listed from the name on, in order
+	for _, record := range out.ResourceRecordSets {
+		if !strings.EqualFold(strings.TrimSuffix(aws.ToString(record.Name), "."), name) {
+			break
+		}
+
+		switch record.Type {
+		case r53types.RRTypeA, r53types.RRTypeCname:
+			if record.AliasTarget != nil || len(record.ResourceRecords) > 0 {
+				return record, nil
+			}
+		}
+	}
+
+	return r53types.ResourceRecordSet{}, fmt.Errorf("the hosted zone %s has no A or CNAME record %s", zone, name)
+}
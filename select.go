@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// selectPolicies are the values of -select.
+var selectPolicies = []string{"first", "newest", "oldest", "random"}
+
+// selectInstance chooses one of the matching instances. Without a -select
+// policy the user is asked to choose when there's more than one.
+func selectInstance(opts *toolOptions, instances []types.Instance) (*types.Instance, error) {
+	if len(instances) == 1 {
+		return &instances[0], nil
+	}
+
+	if opts.selectPolicy != "" {
+		inst := selectByPolicy(opts.selectPolicy, instances)
+		// tell where we landed as nobody chose it
+		fmt.Fprintf(os.Stderr, "%d instances match, selected %s\n", len(instances), describeInstance(*inst))
+		return inst, nil
+	}
+
+	items := make([]string, len(instances))
+	for i, inst := range instances {
+		items[i] = describeInstance(inst)
+	}
+
+	chosen, err := pick(opts, "Multiple instances match:", items)
+	if err != nil {
+		return nil, err
+	}
+
+	return &instances[chosen], nil
+}
+
+// selectByPolicy chooses one of the instances by the -select policy.
+func selectByPolicy(policy string, instances []types.Instance) *types.Instance {
+	switch policy {
+	case "random":
+		r := rand.New(rand.NewSource(time.Now().UnixNano()))
+		return &instances[r.Intn(len(instances))]
+	case "newest", "oldest":
+		sort.SliceStable(instances, func(i, j int) bool {
+			return launchTime(instances[i]).After(launchTime(instances[j]))
+		})
+
+		if policy == "newest" {
+			return &instances[0]
+		}
+		return &instances[len(instances)-1]
+	default:
+		return &instances[0]
+	}
+}
+
+func describeInstance(inst types.Instance) string {
+	desc := *inst.InstanceId
+	if name := tagValue(inst, "Name"); name != "" {
+		desc += " " + name
+	}
+	if inst.PrivateIpAddress != nil {
+		desc += " " + *inst.PrivateIpAddress
+	}
+
+	return fmt.Sprintf("%s (launched %s)", desc, launchTime(inst).Format(time.RFC3339))
+}
+
+func launchTime(inst types.Instance) time.Time {
+	if inst.LaunchTime == nil {
+		return time.Time{}
+	}
+
+	return *inst.LaunchTime
+}
+
+func tagValue(inst types.Instance, key string) string {
+	for _, tag := range inst.Tags {
+		if tag.Key != nil && *tag.Key == key && tag.Value != nil {
+			return *tag.Value
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2instanceconnect"
+)
+
+// setupSerialConsole uploads the public key for the instance's serial console.
+func setupSerialConsole(ctx context.Context, opts *toolOptions, instance *instanceInfo, publicKey string) error {
+	clients, err := clientsForInstance(ctx, opts, instance)
+	if err != nil {
+		return withKind(ErrKeyUploadFailed, err)
+	}
+
+	out, err := clients.connect.SendSerialConsoleSSHPublicKey(ctx, &ec2instanceconnect.SendSerialConsoleSSHPublicKeyInput{
+		InstanceId:   &instance.instanceID,
+		SSHPublicKey: &publicKey,
+	})
+	if isAPIError(err, "SerialConsoleAccessDisabledException") {
+		return withKind(ErrKeyUploadFailed, fmt.Errorf("the serial console access is disabled for your account in %s: %w", instance.region, err))
+	}
+	if err != nil {
+		return withKind(ErrKeyUploadFailed, fmt.Errorf("cannot upload the public key for the serial console: %w", err))
+	}
+
+	if !out.Success {
+		return withKind(ErrKeyUploadFailed, errors.New("unsuccessful uploaded the public key for the serial console"))
+	}
+
+	opts.logf("uploaded the public key for the serial console of %s", instance)
+
+	return nil
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// snapshotMaxAge is how old the snapshot can get before -offline warns about it.
+const snapshotMaxAge = 24 * time.Hour
+
+// snapshot is the inventory of the running instances -offline resolves against.
+type snapshot struct {
+	Taken     time.Time          `json:"taken"`
+	Instances []snapshotInstance `json:"instances"`
+}
+
+type snapshotInstance struct {
+	Region  string         `json:"region"`
+	OwnerID string         `json:"owner_id"`
+	Details types.Instance `json:"details"`
+}
+
+func snapshotPath() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, "ec2-ssh", "snapshot.json"), nil
+}
+
+// takeSnapshot saves the running instances of the regions to scan, narrowed
+// down by the filter options, for -offline.
+func takeSnapshot(ctx context.Context, w io.Writer, args []string) error {
+	opts, _, err := parseArgs(args)
+	if err != nil {
+		return err
+	}
+
+	scan := resolveRegions(ctx, opts)
+	if len(scan) > 1 {
+		if regions, err := enabledRegions(ctx, opts, scan); err == nil && len(regions) > 0 {
+			scan = regions
+		}
+	}
+
+	snap := snapshot{Taken: time.Now().UTC()}
+	for _, region := range scan {
+		instances, err := runningInstances(ctx, opts, region)
+		if isAPIError(err, "OptInRequired") {
+			opts.logf("%s: opt-in required, skipped", region)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("cannot list the instances in %s: %w", region, err)
+		}
+
+		opts.logf("%s: %d instances", region, len(instances))
+		snap.Instances = append(snap.Instances, instances...)
+	}
+
+	path, err := snapshotPath()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+
+	content, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(path, content, 0o600); err != nil {
+		return fmt.Errorf("cannot write the snapshot: %w", err)
+	}
+
+	fmt.Fprintf(w, "saved %d instances of %s to %s\n", len(snap.Instances), strings.Join(scan, ", "), path)
+	return nil
+}
+
+func runningInstances(ctx context.Context, opts *toolOptions, region string) ([]snapshotInstance, error) {
+	clients, err := clientsFor(ctx, opts, region)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get config for AWS: %w", err)
+	}
+
+	input := &ec2.DescribeInstancesInput{
+		Filters: append([]types.Filter{
+			{
+				Name:   strp("instance-state-name"),
+				Values: []string{string(types.InstanceStateNameRunning)},
+			},
+		}, opts.filters()...),
+	}
+
+	var instances []snapshotInstance
+	for {
+		resp, err := clients.ec2.DescribeInstances(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, r := range resp.Reservations {
+			for _, inst := range r.Instances {
+				instances = append(instances, snapshotInstance{Region: region, OwnerID: aws.ToString(r.OwnerId), Details: inst})
+			}
+		}
+
+		if resp.NextToken == nil {
+			return instances, nil
+		}
+		input.NextToken = resp.NextToken
+	}
+}
+
+var loadedSnapshot struct {
+	sync.Once
+	snap *snapshot
+	err  error
+}
+
+// loadSnapshot reads the snapshot once per run, warning if it's old.
+func loadSnapshot() (*snapshot, error) {
+	loadedSnapshot.Do(func() {
+		path, err := snapshotPath()
+		if err != nil {
+			loadedSnapshot.err = err
+			return
+		}
+
+		content, err := os.ReadFile(path)
+		if err != nil {
+			loadedSnapshot.err = fmt.Errorf("cannot read the snapshot, run ec2-ssh snapshot first: %w", err)
+			return
+		}
+
+		snap := &snapshot{}
+		if err := json.Unmarshal(content, snap); err != nil {
+			loadedSnapshot.err = fmt.Errorf("cannot read the snapshot %s: %w", path, err)
+			return
+		}
+
+		if age := time.Since(snap.Taken); age > snapshotMaxAge {
+			fmt.Fprintf(os.Stderr, "the snapshot is %s old, run ec2-ssh snapshot to refresh it\n", age.Round(time.Minute))
+		}
+		loadedSnapshot.snap = snap
+	})
+
+	return loadedSnapshot.snap, loadedSnapshot.err
+}
+
+// regions returns the regions of scan the snapshot has instances in, in the
+// order of scan.
+func (snap *snapshot) regions(scan []string) []string {
+	var regions []string
+	for _, region := range scan {
+		for _, inst := range snap.Instances {
+			if inst.Region == region {
+				regions = append(regions, region)
+				break
+			}
+		}
+	}
+
+	return regions
+}
+
+// findInSnapshot looks for the instance in the region of the snapshot the way
+// findEC2Instance does with the API, applying the same filters.
+func findInSnapshot(opts *toolOptions, info *instanceInfo, region string) (*types.Instance, error) {
+	snap, err := loadSnapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	filters := append(info.filters(opts.instanceStates()), opts.filters()...)
+	owners := map[string]string{}
+	var matches []types.Instance
+	for _, inst := range snap.Instances {
+		if inst.Region == region && matchesFilters(inst.Details, filters) && info.matches(inst.Details) {
+			matches = append(matches, inst.Details)
+			owners[*inst.Details.InstanceId] = inst.OwnerID
+		}
+	}
+
+	ignoredCase := len(matches) == 0 && info.name != ""
+	if ignoredCase {
+		opts.logf("no instance named exactly %s, ignoring the case", info.name)
+		for _, inst := range snap.Instances {
+			name := tagValue(inst.Details, "Name")
+			if inst.Region != region || !matchesFilters(inst.Details, opts.filters()) {
+				continue
+			}
+
+			switch {
+			case info.namePrefix && strings.HasPrefix(strings.ToLower(name), strings.ToLower(info.name)),
+				strings.EqualFold(name, info.name):
+				matches = append(matches, inst.Details)
+				owners[*inst.Details.InstanceId] = inst.OwnerID
+			case similarNames(name, info.name) && !contains(info.nearNames, name):
+				info.nearNames = append(info.nearNames, name)
+			}
+		}
+	}
+
+	if len(matches) == 0 {
+		return nil, nil
+	}
+
+	inst, err := selectInstance(opts, matches)
+	if err != nil {
+		return nil, err
+	}
+
+	if ignoredCase {
+		noteCaseMatch(info, *inst)
+	}
+
+	info.ownerID = owners[*inst.InstanceId]
+	return inst, nil
+}
+
+// matchesFilters applies the EC2 filters ec2-ssh uses to the instance.
+func matchesFilters(inst types.Instance, filters []types.Filter) bool {
+	for _, f := range filters {
+		name := aws.ToString(f.Name)
+
+		var values []string
+		switch {
+		case name == "instance-id":
+			values = []string{aws.ToString(inst.InstanceId)}
+		case name == "instance-state-name":
+			if inst.State != nil {
+				values = []string{string(inst.State.Name)}
+			}
+		case name == "ip-address":
+			values = []string{aws.ToString(inst.PublicIpAddress)}
+		case name == "dns-name":
+			values = []string{aws.ToString(inst.PublicDnsName)}
+		case name == "network-interface.addresses.private-ip-address":
+			values = privateIPs(inst)
+		case name == "vpc-id":
+			values = []string{aws.ToString(inst.VpcId)}
+		case name == "placement-group-name" && inst.Placement != nil:
+			values = []string{aws.ToString(inst.Placement.GroupName)}
+		case name == "host-id" && inst.Placement != nil:
+			values = []string{aws.ToString(inst.Placement.HostId)}
+		case strings.HasPrefix(name, "tag:"):
+			for _, tag := range inst.Tags {
+				if aws.ToString(tag.Key) == strings.TrimPrefix(name, "tag:") {
+					values = []string{aws.ToString(tag.Value)}
+				}
+			}
+		}
+
+		if !anyMatches(f.Values, values) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// anyMatches reports whether any of the values matches any of the patterns,
+// which can have the * and ? wildcards like in the EC2 filters.
+func anyMatches(patterns, values []string) bool {
+	for _, pattern := range patterns {
+		quoted := regexp.QuoteMeta(pattern)
+		quoted = strings.ReplaceAll(quoted, `\*`, ".*")
+		quoted = strings.ReplaceAll(quoted, `\?`, ".")
+		re := regexp.MustCompile("^" + quoted + "$")
+
+		for _, value := range values {
+			if re.MatchString(value) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+func TestAnyMatches(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		values   []string
+		want     bool
+	}{
+		{patterns: []string{"web"}, values: []string{"web"}, want: true},
+		{patterns: []string{"web"}, values: []string{"web-1"}, want: false},
+		{patterns: []string{"web-*"}, values: []string{"web-1"}, want: true},
+		{patterns: []string{"web-*"}, values: []string{"web-"}, want: true},
+		{patterns: []string{"web-?"}, values: []string{"web-12"}, want: false},
+		{patterns: []string{"*.example.com"}, values: []string{"web.example.com"}, want: true},
+		{patterns: []string{"10.0.0.5"}, values: []string{"10.0.0.50", "10.0.0.5"}, want: true},
+		{patterns: []string{"10.0.0.5"}, values: []string{"10.0.0.15"}, want: false},
+		{patterns: []string{"db", "web"}, values: []string{"web"}, want: true},
+		{patterns: []string{"web"}, values: nil, want: false},
+		{patterns: nil, values: []string{"web"}, want: false},
+	}
+
+	for _, tt := range tests {
+		if got := anyMatches(tt.patterns, tt.values); got != tt.want {
+			t.Errorf("anyMatches(%v, %v) = %t, want %t", tt.patterns, tt.values, got, tt.want)
+		}
+	}
+}
+
+func TestMatchesFilters(t *testing.T) {
+	inst := types.Instance{
+		InstanceId: aws.String("i-0123456789abcdef0"),
+		State:      &types.InstanceState{Name: types.InstanceStateNameRunning},
+		VpcId:      aws.String("vpc-1"),
+		Tags:       []types.Tag{{Key: aws.String("Name"), Value: aws.String("web-1")}},
+	}
+
+	tests := []struct {
+		name    string
+		filters []types.Filter
+		want    bool
+	}{
+		{name: "no filters", want: true},
+		{name: "tag wildcard", filters: []types.Filter{{Name: aws.String("tag:Name"), Values: []string{"web-*"}}}, want: true},
+		{name: "other tag value", filters: []types.Filter{{Name: aws.String("tag:Name"), Values: []string{"db"}}}, want: false},
+		{name: "missing tag", filters: []types.Filter{{Name: aws.String("tag:Env"), Values: []string{"*"}}}, want: false},
+		{
+			name: "all filters match",
+			filters: []types.Filter{
+				{Name: aws.String("instance-state-name"), Values: []string{"pending", "running"}},
+				{Name: aws.String("vpc-id"), Values: []string{"vpc-1"}},
+			},
+			want: true,
+		},
+		{
+			name: "one filter doesn't match",
+			filters: []types.Filter{
+				{Name: aws.String("instance-id"), Values: []string{"i-0123456789abcdef0"}},
+				{Name: aws.String("vpc-id"), Values: []string{"vpc-2"}},
+			},
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matchesFilters(inst, tt.filters); got != tt.want {
+				t.Errorf("matchesFilters() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSnapshotRegions(t *testing.T) {
+	snap := &snapshot{Instances: []snapshotInstance{
+		{Region: "us-east-1"},
+		{Region: "eu-west-1"},
+		{Region: "us-east-1"},
+	}}
+
+	tests := []struct {
+		name string
+		scan []string
+		want []string
+	}{
+		{name: "in the order of the regions to scan", scan: []string{"eu-west-1", "us-west-2", "us-east-1"}, want: []string{"eu-west-1", "us-east-1"}},
+		{name: "a single region", scan: []string{"us-east-1"}, want: []string{"us-east-1"}},
+		{name: "no region of the snapshot", scan: []string{"ap-south-1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := snap.regions(tt.scan); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("regions(%v) = %v, want %v", tt.scan, got, tt.want)
+			}
+		})
+	}
+}
@@ -6,70 +6,838 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"os/user"
 	"path/filepath"
 	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
 )
 
 type instanceInfo struct {
 	username  string
 	ipAddress string
-	host      string
+	// host is the real target from the `hostname` of `ssh -G`, used for the EC2 matching
+	host string
+	// alias is the destination as typed by the user, used for display only
+	alias string
+	// name is set when the host doesn't resolve and the instance is looked up by its Name tag
+	name string
+	// publicDNS is set when the host is a CNAME of the instance's public DNS name
+	publicDNS string
+	// publicIP is set when the ipAddress is a public IPv4 address, matched by the instance's public IP
+	publicIP bool
+	// fromAlias is set when the host is an alias from the config file
+	fromAlias bool
+	// ownerID is the AWS account owning the instance
+	ownerID string
+	// byTags is set when the instance is looked up by the -tag filters only
+	byTags bool
+	// byHostname is set when the instance is looked up by a hostname, resolved or not
+	byHostname bool
+	// namePrefix is set when the name is only the prefix of the Name tag
+	namePrefix bool
+	instanceID string
+	// region is set when the region of the instance is known upfront
+	region string
+	// connectIP is set when ssh cannot reach the instance using the host
+	connectIP bool
+
+	availabilityZone string
+	details          *types.Instance
+	userCandidates   []string
+	privateIPs       []string
+	// userGiven is set when the user is given on the command line
+	userGiven bool
+	// userFromTag is set when the user comes from the instance's tags, see tagUser
+	userFromTag bool
+	// resolvedIPs are all the IPs the host resolves to, the first one is the ipAddress
+	resolvedIPs []string
+	// nearNames are the Name tags similar to the name, suggested when nothing matches
+	nearNames []string
 }
 
-var regions = []string{"us-west-1", "us-west-2"}
+// target is the instance the ssh arguments point to.
+type target struct {
+	instance *instanceInfo
+	// found is false if the destination isn't an EC2 instance
+	found bool
+	// options are the ssh options from `ssh -G`
+	options map[string][]string
+	// port is the port ssh connects to, including the one given with -p
+	port string
+	// identityFile is the private key of the uploaded public key, if it's known
+	identityFile string
+	// proxy is the ProxyCommand or ProxyJump from the ssh config, ssh cannot
+	// reach the instance directly when it's set
+	proxy     string
+	publicKey string
+	// args are the arguments for ssh, including the ones derived by ec2-ssh
+	args []string
+	// tmpDir holds the ephemeral key, if any
+	tmpDir string
+	// bastion is the jump host from the instance's bastion tag, if any
+	bastion *target
+	// cachedKey is the cached ephemeral key, see cachedEphemeralKey
+	cachedKey *cachedKey
+}
+
+func (t *target) close() {
+	t.unlockKey()
+	if t.cachedKey != nil {
+		t.cachedKey.release()
+		t.cachedKey = nil
+	}
+	if t.tmpDir != "" {
+		_ = os.RemoveAll(t.tmpDir)
+	}
+	if t.bastion != nil {
+		t.bastion.close()
+	}
+}
 
 func ssh(ctx context.Context, args []string) error {
-	options, err := sshOptions(ctx, args)
+	args, err := expandArgFiles(args)
 	if err != nil {
 		return err
 	}
 
-	instance, err := instanceInfoFromString(options["hostname"][0], options["user"][0])
+	opts, args, err := parseArgs(args)
 	if err != nil {
 		return err
 	}
 
-	pk, err := existingKey(options["identityfile"])
+	if opts.help {
+		usage(os.Stdout)
+		return nil
+	}
+
+	if opts.version {
+		printVersion(os.Stdout)
+		return nil
+	}
+
+	if opts.preConnect != "" {
+		if err := runPreConnect(ctx, opts); err != nil {
+			return err
+		}
+	}
+
+	if opts.asgAll != "" {
+		return asgAll(ctx, opts, args)
+	}
+
+	if opts.tmux {
+		return tmux(ctx, opts, args)
+	}
+
+	if opts.listAliases {
+		return listAliases(ctx, opts, os.Stdout)
+	}
+
+	if opts.listUsers {
+		return listUsers(ctx, opts, args, os.Stdout)
+	}
+
+	if opts.resolveOnly {
+		return printResolved(ctx, opts, args, os.Stdout)
+	}
+
+	if opts.export {
+		return printExports(ctx, opts, args, os.Stdout)
+	}
+
+	if opts.menu {
+		return menu(ctx, opts, args)
+	}
+
+	t, err := authorize(ctx, opts, args)
 	if err != nil {
 		return err
 	}
 
-	publicKey, err := getPublicKey(pk)
-	if err != nil {
-		return fmt.Errorf("cannot read the public key %s.pub. If you want to provide a custom key location, use the `-i` parameter", pk)
+	// the ephemeral key is left for the client to connect with
+	if opts.emitConnection {
+		return emitConnection(t, opts, os.Stdout)
+	}
+	defer t.close()
+
+	return connect(ctx, opts, t)
+}
+
+// connect runs ssh for the authorized target.
+func connect(ctx context.Context, opts *toolOptions, t *target) error {
+	if opts.noConnect {
+		if !t.found {
+			return withKind(ErrNoInstanceFound, fmt.Errorf("%s is not an EC2 instance", t.instance.displayName()))
+		}
+
+		fmt.Printf("OK: uploaded the public key for %s@%s in %s\n", t.instance.username, t.instance, t.instance.region)
+		return nil
+	}
+
+	if err := t.checkForwards(opts.quiet); err != nil {
+		return err
+	}
+
+	// keyscan points ssh to the same file itself
+	if opts.instKnownHosts && t.found && !opts.keyscan {
+		if err := t.useInstanceKnownHosts(opts); err != nil {
+			return err
+		}
+	}
+
+	if opts.keyscan {
+		if err := t.keyscan(ctx, opts); err != nil {
+			return err
+		}
+	}
+
+	var stderr io.Writer = os.Stderr
+	if opts.mergeStderr {
+		stderr = os.Stdout
 	}
 
-	for _, region := range regions {
-		found, err := setupEC2Instance(ctx, instance, publicKey, region)
+	if opts.logSSH != "" {
+		f, err := os.OpenFile(opts.logSSH, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
 		if err != nil {
+			return fmt.Errorf("cannot open the ssh log: %w", err)
+		}
+		defer f.Close()
+
+		stderr = io.MultiWriter(stderr, f)
+	}
+
+	start := time.Now()
+	err := t.session(ctx, opts, stderr)
+	if opts.summary && !opts.quiet {
+		printSummary(os.Stderr, t, time.Since(start), err)
+	}
+
+	return err
+}
+
+// session runs ssh, retrying with the other users or reconnecting as the options say.
+func (t *target) session(ctx context.Context, opts *toolOptions, stderr io.Writer) error {
+	if opts.autoUser {
+		return connectWithUserCandidates(ctx, opts, t.instance, t.publicKey, t.args, stderr)
+	}
+
+	retries, reconnects := 0, 0
+	for attempt := 1; ; attempt++ {
+		stop := t.keepKeyFresh(ctx, opts)
+		head := &headBuffer{max: 4096}
+		err := connectToInstance(ctx, t.args, io.MultiWriter(stderr, head))
+		stop()
+		if attempt >= opts.maxAttempts {
+			return err
+		}
+
+		switch {
+		case retries < opts.retry && connectionFailed(err, head.String()):
+			retries++
+			fmt.Fprintf(os.Stderr, "cannot connect, retrying in %s (%d/%d)\n", opts.reconnectDelay, retries, opts.retry)
+		case reconnects < opts.autoReconnect && connectionDropped(err):
+			reconnects++
+			fmt.Fprintf(os.Stderr, "connection lost, reconnecting in %s (%d/%d)\n", opts.reconnectDelay, reconnects, opts.autoReconnect)
+		default:
 			return err
 		}
 
-		if found {
+		time.Sleep(opts.reconnectDelay)
+
+		// the key is loaded only if it was uploaded in the first place
+		if t.publicKey != "" {
+			if err := t.uploadKey(ctx, opts); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// printSummary prints the one-line recap of the session.
+func printSummary(w io.Writer, t *target, duration time.Duration, err error) {
+	status := "0"
+	var exitErr *exec.ExitError
+	switch {
+	case errors.As(err, &exitErr):
+		status = fmt.Sprint(exitErr.ExitCode())
+	case err != nil:
+		status = err.Error()
+	}
+
+	where := t.instance.displayName()
+	if t.found {
+		where = t.instance.instanceID + " in " + t.instance.region
+	}
+
+	fmt.Fprintf(w, "session to %s@%s ended after %s with exit status %s\n", t.instance.username, where, duration.Round(time.Second), status)
+}
+
+// keyValidity is how long EC2 Instance Connect accepts an uploaded key for.
+const keyValidity = 60 * time.Second
+
+// keyRefreshInterval is how often the key is uploaded again with -keep-key-fresh,
+// a bit less than keyValidity.
+const keyRefreshInterval = 50 * time.Second
+
+// keepKeyFresh uploads the key again in the background for the -keep-key-fresh window,
+// so a handshake taking longer than the key's validity can still authenticate.
+// The returned function stops it.
+func (t *target) keepKeyFresh(ctx context.Context, opts *toolOptions) func() {
+	if opts.keepKeyFresh <= 0 || t.publicKey == "" {
+		return func() {}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, opts.keepKeyFresh)
+	go func() {
+		ticker := time.NewTicker(keyRefreshInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				// a failed refresh doesn't break the connection that may already be up
+				if err := t.uploadKey(ctx, opts); err != nil && ctx.Err() == nil {
+					opts.logf("cannot refresh the public key: %s", err)
+				}
+			}
+		}
+	}()
+
+	return cancel
+}
+
+// connectionDropped reports whether ssh failed itself rather than the remote command,
+// ssh exits with 255 then.
+func connectionDropped(err error) bool {
+	var exitErr *exec.ExitError
+	return errors.As(err, &exitErr) && exitErr.ExitCode() == 255
+}
+
+// connectFailures are the messages of ssh failing to set up the connection,
+// as opposed to losing one that was up.
+var connectFailures = []string{
+	"ssh: connect to host",
+	"kex_exchange_identification",
+	"Connection timed out during banner exchange",
+	"Connection closed by",
+	"Permission denied",
+}
+
+// connectionFailed reports whether ssh couldn't connect or authenticate,
+// judging by the start of its stderr. Failures of the remote command pass.
+func connectionFailed(err error, stderr string) bool {
+	if !connectionDropped(err) {
+		return false
+	}
+
+	for _, msg := range connectFailures {
+		if strings.Contains(stderr, msg) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// resolve finds the instance the arguments point to without changing anything in AWS.
+func resolve(ctx context.Context, opts *toolOptions, args []string) (*target, error) {
+	args, err := expandURL(args)
+	if err != nil {
+		return nil, err
+	}
+
+	// an instance ARN tells both the instance and its region so ssh
+	// only has to know the instance ID
+	arnRegion, alias := "", ""
+	if i := destinationIndex(args); i >= 0 {
+		user, host := splitDestination(args[i])
+		alias = host
+		if strings.HasPrefix(host, "arn:") {
+			instanceID, region, err := instanceFromARN(host)
+			if err != nil {
+				return nil, err
+			}
+
+			args = append(append(args[:i:i], user+instanceID), args[i+1:]...)
+			arnRegion = region
+		}
+	}
+
+	options, err := sshOptions(ctx, opts, args)
+	if err != nil {
+		return nil, err
+	}
+
+	// -o BatchMode=yes for ssh means no prompts from ec2-ssh either
+	if v := options["batchmode"]; len(v) > 0 && v[0] == "yes" {
+		opts.batch = true
+	}
+
+	// without a terminal nobody can answer ssh's prompts, so fail fast rather than hang
+	if opts.autoBatch && !opts.batch && !isTerminal(os.Stdin) && !sshOptionGiven(args, "batchmode") {
+		opts.logf("stdin is not a terminal, running in batch mode")
+		opts.batch = true
+	}
+
+	// the aliases from the config file win over the DNS and the tags
+	shortcut, isShortcut := opts.fileConfig.aliases[options["hostname"][0]]
+
+	var instance *instanceInfo
+	switch {
+	case opts.targetGroup != "":
+		instance, err = instanceFromTargetGroup(ctx, opts, options["user"][0])
+	case opts.matchPrefix != "":
+		instance = &instanceInfo{
+			username:   options["user"][0],
+			host:       options["hostname"][0],
+			name:       opts.matchPrefix,
+			namePrefix: true,
+			connectIP:  true,
+		}
+	case isShortcut && opts.eni == "" && opts.instanceID == "":
+		opts.logf("%s is an alias of %s in %s", options["hostname"][0], shortcut.instanceID, shortcut.region)
+		if !opts.offline {
+			warnStaleAlias(ctx, opts, options["hostname"][0], shortcut, os.Stderr)
+		}
+		instance = &instanceInfo{
+			username:   options["user"][0],
+			host:       options["hostname"][0],
+			instanceID: shortcut.instanceID,
+			region:     shortcut.region,
+			connectIP:  true,
+			fromAlias:  true,
+		}
+	case len(opts.tags) > 0 && opts.eni == "" && opts.targetGroup == "" && opts.instanceID == "":
+		instance = &instanceInfo{
+			username:  options["user"][0],
+			host:      options["hostname"][0],
+			byTags:    true,
+			connectIP: true,
+		}
+	case opts.eni != "":
+		instance, err = instanceFromENI(ctx, opts, options["user"][0])
+	case opts.instanceID != "":
+		// authorize the given instance but let ssh connect wherever the destination points
+		instance = &instanceInfo{
+			username:   options["user"][0],
+			host:       options["hostname"][0],
+			instanceID: opts.instanceID,
+		}
+	default:
+		instance, err = instanceInfoFromString(ctx, opts, options["hostname"][0], options["user"][0])
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if arnRegion != "" {
+		instance.region = arnRegion
+	}
+	instance.userGiven = userGiven(args)
+	instance.alias = alias
+	if instance.ipAddress != "" {
+		opts.logf("%s resolves to %s", instance.displayName(), instance.ipAddress)
+	}
+
+	scan := resolveRegions(ctx, opts)
+	if opts.offline {
+		snap, err := loadSnapshot()
+		if err != nil {
+			return nil, err
+		}
+		scan = snap.regions(scan)
+		if len(scan) == 0 {
+			return nil, withKind(ErrNoInstanceFound, errors.New("the snapshot has no instances in the regions to scan, run ec2-ssh snapshot"))
+		}
+	}
+	if instance.region != "" {
+		scan = []string{instance.region}
+	}
+
+	if len(scan) > 1 && !opts.offline {
+		regions, err := enabledRegions(ctx, opts, scan)
+		switch {
+		case err != nil:
+			opts.logf("scanning all the regions: %s", err)
+		case len(regions) > 0:
+			scan = regions
+		}
+	}
+
+	cache := regionCache{}
+	if instance.name != "" {
+		if region := opts.fileConfig.RegionHint.region(instance.name); region != "" {
+			opts.logf("the name %s hints the region %s", instance.name, region)
+			scan = prependRegion(region, scan)
+		}
+
+		cache = loadRegionCache()
+		scan = cache.regionsToScan(instance.name, scan)
+
+		// the Name tag filter of the Tagging API is exact so prefixes go through the scan
+		if opts.taggingAPI && !instance.namePrefix && len(scan) > 1 {
+			regions, err := regionsWithName(ctx, opts, instance.name, scan)
+			switch {
+			case err != nil:
+				opts.logf("cannot use the Tagging API, scanning all the regions: %s", err)
+			case len(regions) > 0:
+				opts.logf("the Tagging API found %s in %s", instance.name, strings.Join(regions, ", "))
+				scan = regions
+			}
+		}
+	}
+
+	deadline := time.Now().Add(opts.deadline)
+	found := false
+	for {
+		found, err = scanRegions(ctx, opts, instance, scan, cache)
+		if err != nil {
+			return nil, err
+		}
+
+		if found || !opts.waitForInstance {
 			break
 		}
+
+		if time.Now().Add(opts.interval).After(deadline) {
+			return nil, withKind(ErrNoInstanceFound, fmt.Errorf("%s didn't appear in %s in %s", instance.displayName(), strings.Join(scan, ", "), opts.deadline))
+		}
+
+		fmt.Fprintf(os.Stderr, "waiting for %s to appear, retrying in %s\n", instance.displayName(), opts.interval)
+		time.Sleep(opts.interval)
+	}
+
+	if !found && instance.name != "" {
+		suggestion := ""
+		if len(instance.nearNames) > 0 {
+			suggestion = fmt.Sprintf(" Did you mean: %s?", strings.Join(instance.nearNames, ", "))
+		}
+		return nil, withKind(ErrNoInstanceFound, fmt.Errorf("cannot resolve %s and no %s instance with such Name tag was found in %s.%s %s",
+			instance.displayName(), strings.Join(opts.instanceStates(), " or "), strings.Join(scan, ", "), suggestion, notFoundHint(ctx, opts, scan[0])))
+	}
+
+	// the STS call is made only when the hint is going to be shown
+	if !found && !instance.connectIP && opts.verbose {
+		opts.logf("%s is not an EC2 instance in %s, connecting without uploading the key. %s",
+			instance.displayName(), strings.Join(scan, ", "), notFoundHint(ctx, opts, scan[0]))
+	}
+
+	if instance.fromAlias && !found {
+		return nil, withKind(ErrNoInstanceFound, fmt.Errorf("the instance %s of the alias %s is not %s in %s",
+			instance.instanceID, instance.host, strings.Join(opts.instanceStates(), " or "), instance.region))
+	}
+
+	if instance.connectIP && !found {
+		return nil, withKind(ErrNoInstanceFound, fmt.Errorf("cannot find the instance %s in any of the regions: %s. %s", instance, strings.Join(scan, ", "), notFoundHint(ctx, opts, scan[0])))
+	}
+
+	switch {
+	case opts.connectHost != "":
+		args = append([]string{"-o", "HostName=" + opts.connectHost}, args...)
+	case instance.connectIP:
+		args = append([]string{"-o", "HostName=" + instance.ipAddress}, args...)
+	}
+
+	// written before ssh starts so it can be used during the session
+	if found && opts.instanceJSON != "" {
+		if err := writeInstanceJSON(opts.instanceJSON, instance.details); err != nil {
+			return nil, err
+		}
+	}
+
+	if instance.userFromTag {
+		args = append([]string{"-o", "User=" + instance.username}, args...)
+	}
+
+	if found {
+		derived, err := derivedSSHOptions(opts, instance)
+		if err != nil {
+			return nil, err
+		}
+		args = append(derived, args...)
+	}
+
+	if opts.batch {
+		args = append([]string{"-o", "BatchMode=yes"}, args...)
+	}
+
+	if opts.socks != "" {
+		args = append([]string{"-o", "ProxyCommand=nc -X 5 -x " + opts.socks + " %h %p"}, args...)
+	}
+
+	t := &target{
+		instance: instance,
+		found:    found,
+		options:  options,
+		port:     "22",
+		args:     args,
+	}
+	// ssh -G reflects -p and the Port from the ssh config
+	if port := options["port"]; len(port) > 0 {
+		t.port = port[0]
+	}
+	opts.logf("ssh connects to port %s", t.port)
+
+	// -socks wins over the ssh config as its ProxyCommand comes first
+	t.proxy = configuredProxy(options)
+	if opts.socks != "" {
+		t.proxy = opts.socks
+	}
+	if t.proxy != "" {
+		opts.logf("ssh connects through %s", t.proxy)
+	}
+
+	return t, nil
+}
+
+// scanRegions looks for the instance in the regions, in order, logging why
+// it isn't in each of them. The regions access is denied to are skipped, but
+// the denial is returned if the instance isn't found in any other region.
+func scanRegions(ctx context.Context, opts *toolOptions, instance *instanceInfo, scan []string, cache regionCache) (bool, error) {
+	var denied error
+	for _, region := range scan {
+		opts.logf("looking for %s in %s", instance.displayName(), region)
+		found, err := describeEC2Instance(ctx, opts, instance, region)
+		switch {
+		case isAPIError(err, "OptInRequired"):
+			opts.logf("%s: opt-in required, skipped", region)
+			continue
+		case isAPIError(err, "UnauthorizedOperation"):
+			opts.logf("%s: access denied, skipped: %s", region, err)
+			if denied == nil {
+				denied = err
+			}
+			continue
+		case err != nil:
+			return false, err
+		case !found:
+			opts.logf("%s: no instance matched %s", region, describeFilters(append(instance.filters(opts.instanceStates()), opts.filters()...)))
+			continue
+		}
+
+		if instance.name != "" {
+			cache[instance.name] = region
+			// failing to save the cache only makes the next connection slower
+			_ = cache.save()
+		}
+		return true, nil
+	}
+
+	return false, denied
+}
+
+// describeFilters formats the filters for the log, e.g. private-ip-address=10.0.0.5.
+func describeFilters(filters []types.Filter) string {
+	parts := make([]string, len(filters))
+	for i, f := range filters {
+		parts[i] = *f.Name + "=" + strings.Join(f.Values, ",")
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// authorize resolves the instance the arguments point to and uploads the public key to it.
+func authorize(ctx context.Context, opts *toolOptions, args []string) (*target, error) {
+	t, err := resolve(ctx, opts, args)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := t.authorize(ctx, opts); err != nil {
+		t.close()
+		return nil, err
+	}
+
+	if err := t.useBastion(ctx, opts); err != nil {
+		t.close()
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// authorize uploads the public key to the resolved instance.
+func (t *target) authorize(ctx context.Context, opts *toolOptions) error {
+	if opts.serial && !t.found {
+		return withKind(ErrNoInstanceFound, fmt.Errorf("%s is not an EC2 instance so it has no serial console", t.instance.displayName()))
+	}
+
+	// not an EC2 instance so ssh connects as usual
+	if !t.found {
+		return nil
+	}
+
+	// there's no key to upload so don't require one either
+	if opts.readOnly {
+		opts.logf("read-only mode, ssh has to authenticate with a key already authorized on %s", t.instance)
+		return nil
+	}
+
+	if opts.probe && !opts.serial && t.keyAuthorized(ctx, opts) {
+		opts.logf("the key is already authorized on %s, not uploading it", t.instance)
+		return nil
+	}
+
+	if err := t.loadPublicKey(ctx, opts); err != nil {
+		return err
 	}
 
-	return connectToInstance(ctx, args)
+	if t.cachedKey != nil && t.cachedKey.fresh {
+		opts.logf("the ephemeral key was uploaded to %s less than %s ago, not uploading it again", t.instance, keyRefreshInterval)
+	} else if err := t.uploadKey(ctx, opts); err != nil {
+		return err
+	}
+	t.unlockKey()
+
+	if opts.serial {
+		// ssh uses the first value of an option so these win over the destination
+		t.args = append([]string{
+			"-o", "User=" + t.instance.instanceID + ".port0",
+			"-o", "HostName=serial-console.ec2-instance-connect." + t.instance.region + ".aws",
+		}, t.args...)
+	}
+
+	return nil
+}
+
+// unlockKey lets other sessions use the cached ephemeral key.
+func (t *target) unlockKey() {
+	if t.cachedKey != nil && t.cachedKey.unlock != nil {
+		t.cachedKey.unlock()
+		t.cachedKey.unlock = nil
+	}
+}
+
+// uploadKey uploads the loaded public key, again when reconnecting as the upload expires.
+func (t *target) uploadKey(ctx context.Context, opts *toolOptions) error {
+	if opts.serial {
+		return setupSerialConsole(ctx, opts, t.instance, t.publicKey)
+	}
+
+	if err := setupEC2Instance(ctx, opts, t.instance, t.publicKey); err != nil {
+		return err
+	}
+
+	if t.cachedKey != nil {
+		// failing to mark the upload only makes the next session upload the key again
+		_ = os.WriteFile(t.cachedKey.uploaded, nil, 0o600)
+	}
+
+	return nil
+}
+
+// printResolved prints the address of the instance the arguments point to,
+// so the output can be used in scripts.
+func printResolved(ctx context.Context, opts *toolOptions, args []string, w io.Writer) error {
+	t, err := resolve(ctx, opts, args)
+	if err != nil {
+		return err
+	}
+
+	if !t.found {
+		return withKind(ErrNoInstanceFound, fmt.Errorf("%s is not an EC2 instance", t.instance.displayName()))
+	}
+
+	if t.instance.ipAddress == "" {
+		fmt.Fprintln(w, t.instance.instanceID)
+		return nil
+	}
+
+	fmt.Fprintln(w, t.instance.ipAddress)
+	return nil
 }
 
-func sshOptions(ctx context.Context, args []string) (map[string][]string, error) {
+// userGiven reports whether the ssh arguments set the user, with user@host, -l or -o User.
+func userGiven(args []string) bool {
+	dest := destinationIndex(args)
+	if dest < 0 {
+		dest = len(args)
+	} else if strings.Contains(args[dest], "@") {
+		return true
+	}
+
+	// the arguments after the destination are the remote command
+	for _, arg := range args[:dest] {
+		if strings.HasPrefix(arg, "-l") {
+			return true
+		}
+	}
+
+	return sshOptionGiven(args[:dest], "user")
+}
+
+// sshOptionGiven reports whether the ssh arguments set the option with -o.
+func sshOptionGiven(args []string, name string) bool {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "-o" && i+1 < len(args):
+			i++
+			arg = args[i]
+		case strings.HasPrefix(arg, "-o"):
+			arg = arg[2:]
+		default:
+			continue
+		}
+
+		key := strings.FieldsFunc(strings.TrimSpace(arg), func(r rune) bool { return r == '=' || r == ' ' || r == '\t' })
+		if len(key) > 0 && strings.EqualFold(key[0], name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// configuredProxy returns the ProxyCommand or ProxyJump from the ssh options, if any.
+func configuredProxy(options map[string][]string) string {
+	for _, name := range []string{"proxycommand", "proxyjump"} {
+		if v := options[name]; len(v) > 0 && v[0] != "none" {
+			return v[0]
+		}
+	}
+
+	return ""
+}
+
+// splitDestination splits the destination into the user part, including the
+// trailing @, and the host.
+func splitDestination(dest string) (string, string) {
+	i := strings.LastIndex(dest, "@")
+	return dest[:i+1], dest[i+1:]
+}
+
+func sshOptions(ctx context.Context, opts *toolOptions, args []string) (map[string][]string, error) {
 	args = append([]string{"-G"}, args...)
 	cmd := exec.CommandContext(ctx, "ssh", args...)
 
 	s := ""
 	buff := bytes.NewBufferString(s)
+	// the probe should be silent, its warnings are shown only if it fails or in verbose mode
+	stderr := &bytes.Buffer{}
 	cmd.Stdout = buff
-	cmd.Stderr = os.Stdout
+	cmd.Stderr = stderr
 	cmd.Stdin = os.Stdin
 
 	if err := cmd.Run(); err != nil {
-		return nil, err
+		return nil, fmt.Errorf("cannot read the ssh configuration: %w: %s", err, strings.TrimSpace(stderr.String()))
 	}
+
+	if stderr.Len() > 0 {
+		if opts.mergeStderr {
+			_, _ = os.Stdout.Write(stderr.Bytes())
+		}
+		opts.logf("ssh -G: %s", strings.TrimSpace(stderr.String()))
+	}
+
 	res := map[string][]string{}
 
 	scanner := bufio.NewScanner(buff)
@@ -89,21 +857,52 @@ func sshOptions(ctx context.Context, args []string) (map[string][]string, error)
 	return res, nil
 }
 
+// existingKey returns the first of the private keys that is a readable file,
+// telling why the others cannot be used if none can.
 func existingKey(paths []string) (string, error) {
+	var skipped []string
 	for _, path := range paths {
 		path, err := expandHomeDirectoryTilde(path)
 		if err != nil {
 			return "", err
 		}
 
-		if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		err = usableFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			skipped = append(skipped, err.Error())
 			continue
 		}
 
 		return path, nil
 	}
 
-	return "", errors.New("cannot find any ssh key")
+	if len(skipped) > 0 {
+		return "", withKind(ErrNoSSHKey, fmt.Errorf("cannot use any ssh key: %s", strings.Join(skipped, "; ")))
+	}
+
+	return "", withKind(ErrNoSSHKey, errors.New("cannot find any ssh key"))
+}
+
+// usableFile checks that the path is a regular file that can be read.
+func usableFile(path string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	if !fi.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a file", path)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	return f.Close()
 }
 
 // expandHomeDirectoryTilde expands the `~` to path to user's home directory.
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestConfiguredProxy(t *testing.T) {
+	if _, err := exec.LookPath("ssh"); err != nil {
+		t.Skip("ssh is not installed")
+	}
+
+	sshConfig := filepath.Join(t.TempDir(), "config")
+	content := `Host command
+  ProxyCommand ssh -W %h:%p bastion.example.com
+
+Host jump
+  ProxyJump ec2-user@bastion.example.com
+
+Host direct
+  ProxyCommand none
+`
+	if err := os.WriteFile(sshConfig, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		host string
+		want string
+	}{
+		{host: "command", want: "ssh -W %h:%p bastion.example.com"},
+		{host: "jump", want: "ec2-user@bastion.example.com"},
+		{host: "direct", want: ""},
+		{host: "other", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			options, err := sshOptions(context.Background(), &toolOptions{}, []string{"-F", sshConfig, tt.host})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := configuredProxy(options); got != tt.want {
+				t.Errorf("configuredProxy() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
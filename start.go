@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+const (
+	startPollInterval = 5 * time.Second
+	startTimeout      = 5 * time.Minute
+)
+
+// startInstance is a variable so the tests can stub the EC2 calls.
+var startInstance = startEC2Instance
+
+// start starts the stopped instance and points ssh to its new address. The
+// DNS may still point to the old one so the address is taken from the field
+// the instance was matched by, the public DNS name, the public IP or the
+// private IP.
+func (info *instanceInfo) start(ctx context.Context, client *ec2.Client, opts *toolOptions, inst types.Instance) (*types.Instance, error) {
+	started, err := startInstance(ctx, client, opts, inst)
+	if err != nil {
+		return nil, err
+	}
+
+	var address string
+	switch {
+	case info.publicDNS != "":
+		if started.PublicDnsName == nil || *started.PublicDnsName == "" {
+			return nil, fmt.Errorf("the instance %s has no public DNS name after the start", *started.InstanceId)
+		}
+		address = *started.PublicDnsName
+		info.publicDNS = address
+	case info.publicIP:
+		if started.PublicIpAddress == nil || *started.PublicIpAddress == "" {
+			return nil, fmt.Errorf("the instance %s has no public IP after the start", *started.InstanceId)
+		}
+		address = *started.PublicIpAddress
+	default:
+		address = *started.PrivateIpAddress
+	}
+
+	if address != info.ipAddress {
+		opts.logf("%s has the address %s after the start", *started.InstanceId, address)
+	}
+
+	info.ipAddress = address
+	info.connectIP = true
+	return started, nil
+}
+
+// startEC2Instance starts the stopped instance, waits until it's running and
+// returns its fresh description because the addresses may change on start.
+func startEC2Instance(ctx context.Context, client *ec2.Client, opts *toolOptions, inst types.Instance) (*types.Instance, error) {
+	opts.logf("starting %s", *inst.InstanceId)
+
+	_, err := client.StartInstances(ctx, &ec2.StartInstancesInput{
+		InstanceIds: []string{*inst.InstanceId},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot start the instance %s: %w", *inst.InstanceId, err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, startTimeout)
+	defer cancel()
+
+	for {
+		resp, err := client.DescribeInstances(ctx, &ec2.DescribeInstancesInput{
+			InstanceIds: []string{*inst.InstanceId},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("cannot get the state of the instance %s: %w", *inst.InstanceId, err)
+		}
+
+		if len(resp.Reservations) > 0 && len(resp.Reservations[0].Instances) > 0 {
+			started := resp.Reservations[0].Instances[0]
+			if started.State.Name == types.InstanceStateNameRunning {
+				return &started, nil
+			}
+		}
+
+		opts.logf("waiting for %s to be running", *inst.InstanceId)
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("the instance %s didn't start in %s", *inst.InstanceId, startTimeout)
+		case <-time.After(startPollInterval):
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+func TestStartAddress(t *testing.T) {
+	started := types.Instance{
+		InstanceId:       aws.String("i-0123456789abcdef0"),
+		State:            &types.InstanceState{Name: types.InstanceStateNameRunning},
+		PrivateIpAddress: aws.String("10.0.0.7"),
+		PublicIpAddress:  aws.String("198.51.100.7"),
+		PublicDnsName:    aws.String("ec2-198-51-100-7.us-west-2.compute.amazonaws.com"),
+		NetworkInterfaces: []types.InstanceNetworkInterface{{
+			PrivateIpAddresses: []types.InstancePrivateIpAddress{
+				{PrivateIpAddress: aws.String("10.0.0.7")},
+				{PrivateIpAddress: aws.String("10.0.0.8")},
+			},
+		}},
+	}
+	noPublic := started
+	noPublic.PublicIpAddress = nil
+	noPublic.PublicDnsName = nil
+
+	defer func(f func(context.Context, *ec2.Client, *toolOptions, types.Instance) (*types.Instance, error)) {
+		startInstance = f
+	}(startInstance)
+
+	tests := []struct {
+		name    string
+		info    instanceInfo
+		opts    toolOptions
+		started types.Instance
+		want    string
+		wantErr bool
+	}{
+		{
+			name:    "matched by the private IP",
+			info:    instanceInfo{ipAddress: "10.0.0.5"},
+			opts:    toolOptions{ipIndex: -1},
+			started: started,
+			want:    "10.0.0.7",
+		},
+		{
+			name:    "matched by the public IP",
+			info:    instanceInfo{ipAddress: "198.51.100.5", publicIP: true},
+			opts:    toolOptions{ipIndex: -1},
+			started: started,
+			want:    "198.51.100.7",
+		},
+		{
+			name:    "matched by the public DNS name",
+			info:    instanceInfo{ipAddress: "198.51.100.5", publicDNS: "ec2-198-51-100-5.us-west-2.compute.amazonaws.com"},
+			opts:    toolOptions{ipIndex: -1},
+			started: started,
+			want:    "ec2-198-51-100-7.us-west-2.compute.amazonaws.com",
+		},
+		{
+			name:    "-ip-index picks among the new private IPs",
+			info:    instanceInfo{ipAddress: "10.0.0.5"},
+			opts:    toolOptions{ipIndex: 1},
+			started: started,
+			want:    "10.0.0.8",
+		},
+		{
+			name:    "no public IP after the start",
+			info:    instanceInfo{ipAddress: "198.51.100.5", publicIP: true},
+			opts:    toolOptions{ipIndex: -1},
+			started: noPublic,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			startInstance = func(context.Context, *ec2.Client, *toolOptions, types.Instance) (*types.Instance, error) {
+				started := tt.started
+				return &started, nil
+			}
+
+			opts := tt.opts
+			info := tt.info
+			stopped := types.Instance{InstanceId: tt.started.InstanceId}
+
+			inst, err := info.start(context.Background(), nil, &opts, stopped)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("start() succeeded with the address %s, want an error", info.ipAddress)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			info.privateIPs = privateIPs(*inst)
+			if err := info.selectIP(&opts); err != nil {
+				t.Fatal(err)
+			}
+
+			if info.ipAddress != tt.want || !info.connectIP {
+				t.Errorf("address = %s, connectIP = %t, want %s, true", info.ipAddress, info.connectIP, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	tagging "github.com/aws/aws-sdk-go-v2/service/resourcegroupstaggingapi"
+	taggingtypes "github.com/aws/aws-sdk-go-v2/service/resourcegroupstaggingapi/types"
+)
+
+// regionsWithName asks the Resource Groups Tagging API of all the regions at once
+// which of them have an instance with the Name tag, so only those are scanned.
+func regionsWithName(ctx context.Context, opts *toolOptions, name string, scan []string) ([]string, error) {
+	found := make([]bool, len(scan))
+	errs := make([]error, len(scan))
+
+	var wg sync.WaitGroup
+	for i, region := range scan {
+		wg.Add(1)
+		go func(i int, region string) {
+			defer wg.Done()
+
+			clients, err := clientsFor(ctx, opts, region)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+
+			out, err := tagging.NewFromConfig(clients.cfg).GetResources(ctx, &tagging.GetResourcesInput{
+				ResourceTypeFilters: []string{"ec2:instance"},
+				TagFilters: []taggingtypes.TagFilter{
+					{
+						Key:    strp("Name"),
+						Values: []string{name},
+					},
+				},
+			})
+			if err != nil {
+				errs[i] = fmt.Errorf("%s: %w", region, err)
+				return
+			}
+
+			found[i] = len(out.ResourceTagMappingList) > 0
+		}(i, region)
+	}
+	wg.Wait()
+
+	var regions []string
+	for i, region := range scan {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		if found[i] {
+			regions = append(regions, region)
+		}
+	}
+
+	return regions, nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	elbv2 "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+)
+
+// instanceFromTargetGroup resolves the instance to connect to from the targets
+// registered in a load balancer target group. Targets are either instance IDs
+// or IP addresses, depending on the target group's target type.
+func instanceFromTargetGroup(ctx context.Context, opts *toolOptions, user string) (*instanceInfo, error) {
+	tg, err := parseARN(opts.targetGroup)
+	if err != nil {
+		return nil, err
+	}
+
+	if tg.service != "elasticloadbalancing" {
+		return nil, fmt.Errorf("%s is not a target group ARN", opts.targetGroup)
+	}
+
+	clients, err := clientsFor(ctx, opts, tg.region)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get config for AWS: %w", err)
+	}
+
+	resp, err := elbv2.NewFromConfig(clients.cfg).DescribeTargetHealth(ctx, &elbv2.DescribeTargetHealthInput{
+		TargetGroupArn: &opts.targetGroup,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot describe the target group: %w", err)
+	}
+
+	var targets, items []string
+	for _, desc := range resp.TargetHealthDescriptions {
+		state := string(desc.TargetHealth.State)
+		if !opts.acceptsTargetHealth(state) {
+			continue
+		}
+
+		targets = append(targets, *desc.Target.Id)
+		items = append(items, fmt.Sprintf("%s (%s)", *desc.Target.Id, state))
+	}
+
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no targets in state %s found in the target group", strings.Join(opts.targetHealth, ", "))
+	}
+
+	chosen := 0
+	if len(targets) > 1 {
+		chosen, err = pick(opts, "Multiple targets found:", items)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	info := &instanceInfo{
+		username:  user,
+		host:      targets[chosen],
+		region:    tg.region,
+		connectIP: true,
+	}
+
+	if strings.HasPrefix(targets[chosen], "i-") {
+		info.instanceID = targets[chosen]
+	} else {
+		info.ipAddress = targets[chosen]
+	}
+
+	return info, nil
+}
+
+func (opts *toolOptions) acceptsTargetHealth(state string) bool {
+	for _, s := range opts.targetHealth {
+		if s == "all" || s == state {
+			return true
+		}
+	}
+
+	return false
+}
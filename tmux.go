@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// tmux authorizes every destination and opens a tmux window with one ssh pane per destination.
+// All destinations are authorized before any pane opens, so failures are reported up front.
+func tmux(ctx context.Context, opts *toolOptions, args []string) error {
+	if _, err := exec.LookPath("tmux"); err != nil {
+		return errors.New("tmux is not installed")
+	}
+
+	sshArgs, destinations := splitDestinations(args)
+	if len(destinations) == 0 {
+		return errors.New("no destinations given")
+	}
+
+	// the panes use the ephemeral keys of the targets, deleted when they're closed
+	var targets []*target
+	defer func() {
+		for _, t := range targets {
+			t.close()
+		}
+	}()
+
+	var commands, failures []string
+	for _, dest := range destinations {
+		t, err := authorize(ctx, opts, append(sshArgs, dest))
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %s", dest, err))
+			continue
+		}
+		targets = append(targets, t)
+
+		if opts.autoUser {
+			t.args = append([]string{"-o", "User=" + t.instance.username}, t.args...)
+		}
+
+		commands = append(commands, shellCommand(append([]string{"ssh"}, t.args...)))
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("cannot authorize all the destinations:\n%s", strings.Join(failures, "\n"))
+	}
+
+	// inside tmux open a new window, otherwise start a new session and attach to it
+	insideTmux := os.Getenv("TMUX") != ""
+	create := []string{"new-session", "-d"}
+	if insideTmux {
+		create = []string{"new-window"}
+	}
+
+	window, err := tmuxCommand(ctx, append(create, "-P", "-F", "#{window_id}", commands[0])...)
+	if err != nil {
+		return err
+	}
+
+	for _, command := range commands[1:] {
+		if _, err := tmuxCommand(ctx, "split-window", "-t", window, command); err != nil {
+			return err
+		}
+
+		// re-tile after every split so tmux doesn't run out of space for the next pane
+		if _, err := tmuxCommand(ctx, "select-layout", "-t", window, "tiled"); err != nil {
+			return err
+		}
+	}
+
+	if opts.syncPanes {
+		if _, err := tmuxCommand(ctx, "set-window-option", "-t", window, "synchronize-panes", "on"); err != nil {
+			return err
+		}
+	}
+
+	if insideTmux {
+		if opts.ephemeral {
+			// ssh reads the key when it authenticates, which it cannot do after the upload expires
+			fmt.Fprintf(os.Stderr, "keeping the ephemeral keys for the panes for %s\n", keyValidity)
+			select {
+			case <-ctx.Done():
+			case <-time.After(keyValidity):
+			}
+		}
+		return nil
+	}
+
+	cmd := exec.CommandContext(ctx, "tmux", "attach-session", "-t", window)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	return cmd.Run()
+}
+
+func tmuxCommand(ctx context.Context, args ...string) (string, error) {
+	out, err := exec.CommandContext(ctx, "tmux", args...).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("tmux %s failed: %w: %s", args[0], err, strings.TrimSpace(string(out)))
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
+// splitDestinations splits the ssh arguments into options and the destinations following them.
+func splitDestinations(args []string) ([]string, []string) {
+	i := destinationIndex(args)
+	if i < 0 {
+		return args, nil
+	}
+
+	return args[:i:i], args[i:]
+}
+
+func shellCommand(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = shellQuote(arg)
+	}
+
+	return strings.Join(quoted, " ")
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
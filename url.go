@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// destinationFromURL turns a destination like ssh://ec2-user@web:2222, as copied from
+// other tools, into the user@host destination and the port, if any. Other
+// destinations are returned as they are.
+func destinationFromURL(dest string) (string, string, error) {
+	if !strings.Contains(dest, "://") {
+		return dest, "", nil
+	}
+
+	u, err := url.Parse(dest)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid destination %q: %w", dest, err)
+	}
+
+	if u.Scheme != "ssh" {
+		return "", "", fmt.Errorf("unsupported scheme %s:// of %s, only ssh:// is supported", u.Scheme, dest)
+	}
+
+	if u.Hostname() == "" || (u.Path != "" && u.Path != "/") {
+		return "", "", fmt.Errorf("invalid destination %q, expected ssh://[user@]host[:port]", dest)
+	}
+
+	host := u.Hostname()
+	if u.User != nil {
+		host = u.User.Username() + "@" + host
+	}
+
+	return host, u.Port(), nil
+}
+
+// expandURL replaces an ssh:// destination in the arguments with user@host,
+// preceded by -p if it has the port.
+func expandURL(args []string) ([]string, error) {
+	i := destinationIndex(args)
+	if i < 0 {
+		return args, nil
+	}
+
+	dest, port, err := destinationFromURL(args[i])
+	if err != nil || dest == args[i] {
+		return args, err
+	}
+
+	expanded := append([]string{}, args[:i]...)
+	if port != "" {
+		expanded = append(expanded, "-p", port)
+	}
+
+	return append(append(expanded, dest), args[i+1:]...), nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDestinationFromURL(t *testing.T) {
+	tests := []struct {
+		dest    string
+		want    string
+		port    string
+		wantErr bool
+	}{
+		{dest: "ec2-user@web", want: "ec2-user@web"},
+		{dest: "ssh://web", want: "web"},
+		{dest: "ssh://ec2-user@web:2222", want: "ec2-user@web", port: "2222"},
+		{dest: "ssh://ec2-user@web/", want: "ec2-user@web"},
+		{dest: "ssh://[fd00::5]:22", want: "fd00::5", port: "22"},
+		{dest: "https://web", wantErr: true},
+		{dest: "ssh://", wantErr: true},
+		{dest: "ssh://ec2-user@:22", wantErr: true},
+		{dest: "ssh://web/home", wantErr: true},
+		{dest: "ssh://web:port", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.dest, func(t *testing.T) {
+			got, port, err := destinationFromURL(tt.dest)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("destinationFromURL() = %s, %s, want an error", got, port)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want || port != tt.port {
+				t.Errorf("destinationFromURL() = %s, %s, want %s, %s", got, port, tt.want, tt.port)
+			}
+		})
+	}
+}
+
+func TestExpandURL(t *testing.T) {
+	tests := []struct {
+		args []string
+		want []string
+	}{
+		{args: []string{"-v", "ssh://ec2-user@web:2222", "uptime"}, want: []string{"-v", "-p", "2222", "ec2-user@web", "uptime"}},
+		{args: []string{"ssh://web"}, want: []string{"web"}},
+		{args: []string{"-i", "key", "web"}, want: []string{"-i", "key", "web"}},
+	}
+
+	for _, tt := range tests {
+		got, err := expandURL(tt.args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("expandURL(%v) = %v, want %v", tt.args, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// amiUsers maps fragments of AMI names to the default users of those images.
+// The first matching fragment wins so more specific ones go first.
+// The names of the arm64 (Graviton) images differ only by the architecture, e.g.
+// al2023-ami-2023.1.20230725.0-kernel-6.1-arm64, amzn2-ami-kernel-5.10-hvm-2.0.20230628.0-arm64-gp2,
+// ubuntu/images/hvm-ssd/ubuntu-jammy-22.04-arm64-server-20230516, debian-12-arm64-20230612-1409
+// or RHEL-9.2.0_HVM-20230503-arm64-41-Hourly2-GP2, so the fragments have to avoid the architecture.
+var amiUsers = []struct {
+	fragment string
+	users    []string
+}{
+	{"ubuntu", []string{"ubuntu"}},
+	{"debian", []string{"admin"}},
+	{"amzn", []string{"ec2-user"}},
+	{"al2023", []string{"ec2-user"}},
+	{"rhel", []string{"ec2-user", "root"}},
+	{"centos", []string{"centos", "ec2-user"}},
+	{"fedora", []string{"fedora", "ec2-user"}},
+	{"suse", []string{"ec2-user", "root"}},
+	{"bitnami", []string{"bitnami"}},
+}
+
+// fallbackUsers are tried when the AMI doesn't tell us anything.
+var fallbackUsers = []string{"ec2-user", "ubuntu", "admin", "centos", "root"}
+
+// userCandidates returns the likely OS users of the instance, most likely first.
+func userCandidates(ctx context.Context, client *ec2.Client, inst types.Instance) ([]string, error) {
+	name, err := imageName(ctx, client, *inst.ImageId)
+	if err != nil {
+		return nil, err
+	}
+
+	return usersForImage(name), nil
+}
+
+// imageName returns the name of the AMI or an empty string if the AMI
+// is deregistered or private to another account.
+func imageName(ctx context.Context, client *ec2.Client, imageID string) (string, error) {
+	resp, err := client.DescribeImages(ctx, &ec2.DescribeImagesInput{
+		ImageIds: []string{imageID},
+	})
+	if err != nil {
+		return "", fmt.Errorf("cannot describe the AMI %s: %w", imageID, err)
+	}
+
+	if len(resp.Images) == 0 || resp.Images[0].Name == nil {
+		return "", nil
+	}
+
+	return *resp.Images[0].Name, nil
+}
+
+// listUsers prints the likely OS users of the instance the arguments point to.
+func listUsers(ctx context.Context, opts *toolOptions, args []string, w io.Writer) error {
+	t, err := resolve(ctx, opts, args)
+	if err != nil {
+		return err
+	}
+
+	if !t.found {
+		return fmt.Errorf("%s is not an EC2 instance", t.instance.displayName())
+	}
+
+	clients, err := clientsFor(ctx, opts, t.instance.region)
+	if err != nil {
+		return fmt.Errorf("cannot get config for AWS: %w", err)
+	}
+
+	imageID := *t.instance.details.ImageId
+	name, err := imageName(ctx, clients.ec2, imageID)
+	if err != nil {
+		return err
+	}
+
+	if name == "" {
+		name = "unknown"
+	}
+
+	fmt.Fprintf(w, "Instance: %s\n", t.instance)
+	fmt.Fprintf(w, "AMI: %s (%s)\n", imageID, name)
+	fmt.Fprintf(w, "Architecture: %s\n", t.instance.details.Architecture)
+	fmt.Fprintf(w, "Likely users: %s\n", strings.Join(usersForImage(name), ", "))
+
+	return nil
+}
+
+func usersForImage(name string) []string {
+	name = strings.ToLower(name)
+
+	candidates := []string{}
+	for _, m := range amiUsers {
+		if strings.Contains(name, m.fragment) {
+			candidates = append(candidates, m.users...)
+			break
+		}
+	}
+
+	for _, user := range fallbackUsers {
+		if !contains(candidates, user) {
+			candidates = append(candidates, user)
+		}
+	}
+
+	return candidates
+}
+
+// connectWithUserCandidates connects as the first candidate user and, when ssh
+// fails with "Permission denied", uploads the key for the next candidate and
+// tries again until the candidates are exhausted.
+func connectWithUserCandidates(ctx context.Context, opts *toolOptions, instance *instanceInfo, publicKey string, args []string, stderr io.Writer) error {
+	var err error
+	for i, user := range instance.userCandidates {
+		if i >= opts.maxAttempts {
+			fmt.Fprintf(os.Stderr, "giving up after %d attempts, raise -max-attempts to try more users\n", i)
+			break
+		}
+
+		if i > 0 {
+			opts.logf("permission denied, retrying as %s", user)
+
+			instance.username = user
+			if err := setupEC2Instance(ctx, opts, instance, publicKey); err != nil {
+				return err
+			}
+		}
+
+		head := &headBuffer{max: 4096}
+		err = connectToInstance(ctx, append([]string{"-o", "User=" + user}, args...), io.MultiWriter(stderr, head))
+		if err == nil || !permissionDenied(err, head.String()) {
+			return err
+		}
+	}
+
+	return err
+}
+
+func permissionDenied(err error, stderr string) bool {
+	var exitErr *exec.ExitError
+	return errors.As(err, &exitErr) && exitErr.ExitCode() == 255 && strings.Contains(stderr, "Permission denied")
+}
+
+// headBuffer keeps only the first max bytes written to it so it can capture
+// the authentication errors of a long-running session.
+type headBuffer struct {
+	bytes.Buffer
+	max int
+}
+
+func (b *headBuffer) Write(p []byte) (int, error) {
+	if left := b.max - b.Len(); left > 0 {
+		if len(p) > left {
+			b.Buffer.Write(p[:left])
+		} else {
+			b.Buffer.Write(p)
+		}
+	}
+
+	return len(p), nil
+}
+
+// userTag is the instance tag naming the OS user to log in as.
+const userTag = "ec2-ssh:user"
+
+// tagUser returns the user to log in as chosen by the instance's tags and where
+// it comes from: the ec2-ssh:user tag or else the first user rule of the config
+// file all the tags of which the instance has.
+func tagUser(opts *toolOptions, inst types.Instance) (string, string) {
+	if user := tagValue(inst, userTag); validUsername(user) {
+		return user, "the " + userTag + " tag"
+	}
+
+	for i, rule := range opts.fileConfig.UserRules {
+		if rule.matches(inst) {
+			return rule.User, fmt.Sprintf("the user rule %d", i+1)
+		}
+	}
+
+	return "", ""
+}
+
+func (rule userRule) matches(inst types.Instance) bool {
+	for key, value := range rule.Tags {
+		if tagValue(inst, key) != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+var usernameRegexp = regexp.MustCompile(`^[a-z_][a-z0-9_.-]{0,31}$`)
+
+func validUsername(user string) bool {
+	return usernameRegexp.MatchString(user)
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
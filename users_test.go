@@ -0,0 +1,29 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUsersForImage(t *testing.T) {
+	tests := []struct {
+		image string
+		want  []string
+	}{
+		{image: "al2023-ami-2023.1.20230725.0-kernel-6.1-arm64", want: []string{"ec2-user", "ubuntu", "admin", "centos", "root"}},
+		{image: "amzn2-ami-kernel-5.10-hvm-2.0.20230628.0-arm64-gp2", want: []string{"ec2-user", "ubuntu", "admin", "centos", "root"}},
+		{image: "ubuntu/images/hvm-ssd/ubuntu-jammy-22.04-arm64-server-20230516", want: []string{"ubuntu", "ec2-user", "admin", "centos", "root"}},
+		{image: "debian-12-arm64-20230612-1409", want: []string{"admin", "ec2-user", "ubuntu", "centos", "root"}},
+		{image: "RHEL-9.2.0_HVM-20230503-arm64-41-Hourly2-GP2", want: []string{"ec2-user", "root", "ubuntu", "admin", "centos"}},
+		{image: "al2023-ami-2023.1.20230725.0-kernel-6.1-x86_64", want: []string{"ec2-user", "ubuntu", "admin", "centos", "root"}},
+		{image: "unknown", want: []string{"ec2-user", "ubuntu", "admin", "centos", "root"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.image, func(t *testing.T) {
+			if got := usersForImage(tt.image); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("usersForImage(%q) = %v, want %v", tt.image, got, tt.want)
+			}
+		})
+	}
+}
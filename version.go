@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"runtime/debug"
+)
+
+// version can be set at build time with -ldflags "-X main.version=...",
+// otherwise the module version from the build info is used.
+var version = ""
+
+func printVersion(w io.Writer) {
+	toolVersion, sdkVersion := version, "unknown"
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if toolVersion == "" {
+			toolVersion = info.Main.Version
+		}
+
+		for _, dep := range info.Deps {
+			if dep.Path != "github.com/aws/aws-sdk-go-v2" {
+				continue
+			}
+
+			sdkVersion = dep.Version
+			if dep.Replace != nil {
+				sdkVersion = dep.Replace.Version
+			}
+		}
+	}
+
+	if toolVersion == "" {
+		toolVersion = "unknown"
+	}
+
+	fmt.Fprintf(w, "ec2-ssh %s\n", toolVersion)
+	fmt.Fprintf(w, "Go: %s\n", runtime.Version())
+	fmt.Fprintf(w, "AWS SDK: %s\n", sdkVersion)
+	fmt.Fprintf(w, "Platform: %s/%s\n", runtime.GOOS, runtime.GOARCH)
+}